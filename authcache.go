@@ -4,10 +4,14 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"sync/atomic"
 )
 
 type AuthCache struct {
 	Domain map[string]AuthPaths
+
+	// hits and misses count the calls to Get
+	hits, misses uint64
 }
 
 func NewAuthCache() *AuthCache {
@@ -16,39 +20,46 @@ func NewAuthCache() *AuthCache {
 	}
 }
 
-func (c *AuthCache) Get(uri *url.URL) (auth string) {
-	paths, ok := c.Domain[uri.Host]
-	if !ok {
-		return
-	}
-	for _, v := range paths {
+func (c *AuthCache) Get(uri *url.URL) (challenge *Challenge, auth string) {
+	for _, v := range c.Domain[uri.Host] {
 		if v.Matches(uri.Path) {
-			return v.Auth
+			atomic.AddUint64(&c.hits, 1)
+			return v.Challenge, v.Auth
 		}
 	}
+	atomic.AddUint64(&c.misses, 1)
 	return
 }
 
-func (c *AuthCache) Set(uri *url.URL, auth string) {
+// Stats returns the number of calls to Get that found a cached
+// challenge, and the number that did not.
+func (c *AuthCache) Stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+func (c *AuthCache) Set(uri *url.URL, challenge *Challenge, auth string) {
 	pairs := c.Domain[uri.Host]
-	if pairs != nil {
-		for _, v := range pairs {
-			if v.Path == uri.Path {
-				v.Auth = auth
-				return
-			}
+	for i := range pairs {
+		if pairs[i].Path == uri.Path {
+			pairs[i].Challenge = challenge
+			pairs[i].Auth = auth
+			return
 		}
 	}
-	pairs = append(pairs, AuthPath{Path: uri.Path, Auth: auth})
+	pairs = append(pairs, AuthPath{Path: uri.Path, Challenge: challenge, Auth: auth})
 	sort.Sort(pairs)
 	c.Domain[uri.Host] = pairs
 }
 
 type AuthPaths []AuthPath
 
+// AuthPath pairs a path with the challenge that was answered
+// successfully for it, and the Authorization header value that was
+// sent in response.
 type AuthPath struct {
-	Path string
-	Auth string
+	Path      string
+	Challenge *Challenge
+	Auth      string
 }
 
 func (ap AuthPath) Matches(path string) bool {
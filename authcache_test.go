@@ -54,6 +54,21 @@ func TestAuthPathMatches(t *testing.T) {
 	}
 }
 
+func TestAuthCacheSetReplace(t *testing.T) {
+	uri, err := url.Parse("http://example.com/1/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewAuthCache()
+	cache.Set(uri, nil, "a")
+	cache.Set(uri, nil, "b")
+
+	if _, auth := cache.Get(uri); auth != "b" {
+		t.Errorf("expected replaced value b, got %s", auth)
+	}
+}
+
 func BenchmarkAuthPathsSort(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		b.StopTimer()
@@ -85,7 +100,7 @@ func BenchmarkAuthPathsSet(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		cache := NewAuthCache()
 		for j := 0; j < ops; j++ {
-			cache.Set(uris[j], auth[j])
+			cache.Set(uris[j], nil, auth[j])
 		}
 	}
 }
@@ -0,0 +1,257 @@
+package httpclient
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/jimrobinson/lexrec"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// AuthenticationInfo holds the parameters of an Authentication-Info
+// header sent by a server following a successful Digest
+// authentication.
+type AuthenticationInfo struct {
+	NextNonce string
+	Qop       string
+	Rspauth   string
+	Cnonce    string
+	Nc        string
+}
+
+// MutualAuthError is returned when a server's Authentication-Info
+// does not prove that it knows the credentials used to authorize
+// the request, indicating that the response may have been spoofed.
+type MutualAuthError struct {
+	URL    string
+	Reason string
+}
+
+func (e *MutualAuthError) Error() string {
+	return fmt.Sprintf("mutual authentication of %s failed: %s", e.URL, e.Reason)
+}
+
+// AuthInfo returns the parsed Authentication-Info header of rsp, or
+// nil if the header was not sent.
+func AuthInfo(rsp *http.Response) (info *AuthenticationInfo, err error) {
+	s := rsp.Header.Get("Authentication-Info")
+	if s == "" {
+		return nil, nil
+	}
+	return parseAuthenticationInfo(s)
+}
+
+func parseAuthenticationInfo(s string) (info *AuthenticationInfo, err error) {
+	r := strings.NewReader(s)
+	rec := lexrec.NewRecord(256, nil, func(l *lexrec.Lexer) {})
+
+	var l *lexrec.Lexer
+	l, err = lexrec.NewLexerRun("ParseAuthenticationInfo", r, rec, emitAuthenticationInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	info = &AuthenticationInfo{}
+
+	for {
+		item := l.NextItem()
+		if item.Type == lexrec.ItemEOF {
+			break
+		} else if item.Type == lexrec.ItemError {
+			err = fmt.Errorf("error at position %d: %s", item.Pos, item.Value)
+			return nil, err
+		}
+
+		switch item.Type {
+		case ItemNextnonce:
+			info.NextNonce = unquote(item.Value)
+		case ItemQop:
+			info.Qop = unquote(item.Value)
+		case ItemRspauth:
+			info.Rspauth = unquote(item.Value)
+		case ItemCnonce:
+			info.Cnonce = unquote(item.Value)
+		case ItemNc:
+			info.Nc = unquote(item.Value)
+		case ItemAuthParam:
+		default:
+			err = fmt.Errorf("unhandled item type %d at position %d: %v", item.Type, item.Pos, item.Value)
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// unquote strips the surrounding quotes from a quoted-string value,
+// returning token values unchanged.
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// Verify checks the rspauth value of info against the H(A1) cached
+// in session when the challenge was answered for req.  The rspauth
+// expected is computed from the qop, nc and cnonce sent with req.  A
+// *MutualAuthError is returned if the rspauth does not match, or if
+// info echoes a qop, nc or cnonce other than the one sent with req.
+// If info carries no rspauth, there is nothing to verify.
+func (challenge *Challenge) Verify(session Session, req *http.Request, rsp *http.Response, info *AuthenticationInfo) (err error) {
+	if info.Rspauth == "" {
+		return nil
+	}
+
+	newHash, ok := digestHash(challenge.Algorithm)
+	if !ok {
+		err = fmt.Errorf("unhandled algorithm: %s", challenge.Algorithm)
+		return
+	}
+
+	fail := func(reason string) error {
+		return &MutualAuthError{URL: req.URL.String(), Reason: reason}
+	}
+
+	header := "Authorization"
+	if challenge.Proxy != nil {
+		header = "Proxy-Authorization"
+	}
+
+	sent := digestParams(req.Header.Get(header))
+	qop, nc, cnonce := sent["qop"], sent["nc"], sent["cnonce"]
+
+	if info.Cnonce != "" && info.Cnonce != cnonce {
+		return fail("cnonce does not match request")
+	}
+	if info.Nc != "" && info.Nc != nc {
+		return fail("nc does not match request")
+	}
+	if info.Qop != "" && info.Qop != qop {
+		return fail("qop does not match request")
+	}
+
+	_, ha1 := session.DigestCredentials(challenge.origin(req), challenge.Realm, challenge.Algorithm)
+	if isSessionAlgorithm(challenge.Algorithm) {
+		ha1 = session.DigestSession(challenge.origin(req).Host, challenge.Nonce, challenge.Algorithm)
+	}
+	if ha1 == "" {
+		return fail("no cached credentials")
+	}
+
+	// RFC 7616 3.5 A2 omits the method
+	var ha2 string
+
+	if qop == "" || qop == "auth" {
+		// A2 = ":" digest-uri-value
+
+		h := newHash()
+		io.WriteString(h, ":")
+		io.WriteString(h, challenge.digestURI(req))
+
+		ha2 = fmt.Sprintf("%x", h.Sum(nil))
+
+	} else if qop == "auth-int" {
+		// A2 = ":" digest-uri-value ":" H(entity-body)
+
+		h := newHash()
+
+		io.WriteString(h, ":")
+		io.WriteString(h, challenge.digestURI(req))
+		io.WriteString(h, ":")
+
+		hb := newHash()
+		if rsp.Body != nil {
+			prc := session.NewProxyReadCloser()
+			mw := io.MultiWriter(hb, prc)
+
+			_, err = io.Copy(mw, rsp.Body)
+			rsp.Body.Close()
+			if err != nil {
+				return
+			}
+
+			err = prc.Close()
+			if err != nil {
+				return
+			}
+
+			rsp.Body, err = prc.ReadCloser()
+			if err != nil {
+				return
+			}
+		}
+		io.WriteString(h, fmt.Sprintf("%x", hb.Sum(nil)))
+
+		ha2 = fmt.Sprintf("%x", h.Sum(nil))
+	} else {
+		return fail(fmt.Sprintf("unrecognized qop %q", qop))
+	}
+
+	h := newHash()
+
+	io.WriteString(h, ha1)
+	io.WriteString(h, ":")
+	io.WriteString(h, challenge.Nonce)
+	if qop != "" {
+		io.WriteString(h, ":")
+		io.WriteString(h, nc)
+		io.WriteString(h, ":")
+		io.WriteString(h, cnonce)
+		io.WriteString(h, ":")
+		io.WriteString(h, qop)
+	}
+	io.WriteString(h, ":")
+	io.WriteString(h, ha2)
+
+	rspauth := fmt.Sprintf("%x", h.Sum(nil))
+
+	if subtle.ConstantTimeCompare([]byte(rspauth), []byte(strings.ToLower(info.Rspauth))) != 1 {
+		return fail("rspauth does not match")
+	}
+
+	return nil
+}
+
+// digestParams returns the parameters of auth, a Digest Authorization
+// header value as built by Challenge.Digest, by lowercased name.
+// Quoted values are returned without their quotes.
+func digestParams(auth string) map[string]string {
+	params := make(map[string]string)
+	s := strings.TrimPrefix(auth, "Digest ")
+	for s != "" {
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			break
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = s[i+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			j := 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:j], s[j+1:]
+			}
+		} else {
+			j := strings.IndexByte(s, ',')
+			if j < 0 {
+				j = len(s)
+			}
+			value, s = s[:j], s[j:]
+		}
+
+		params[name] = value
+		s = strings.TrimLeft(s, ", ")
+	}
+	return params
+}
@@ -0,0 +1,115 @@
+package httpclient
+
+import (
+	"crypto/md5"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type authInfoExpect struct {
+	Header string
+	Parsed AuthenticationInfo
+}
+
+var authInfoTests = []authInfoExpect{
+	{`nextnonce="dcd98b7102dd2f0e8b11d0f600bfb0c093"`,
+		AuthenticationInfo{
+			NextNonce: "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+		},
+	},
+	{`qop=auth,
+		rspauth="6629fae49393a05397450978507c4ef1",
+		cnonce="0a4f113b", nc=00000001`,
+		AuthenticationInfo{
+			Qop:     "auth",
+			Rspauth: "6629fae49393a05397450978507c4ef1",
+			Cnonce:  "0a4f113b",
+			Nc:      "00000001",
+		},
+	},
+	{`qop="auth-int", nc="00000002", unrecognized=ignored`,
+		AuthenticationInfo{
+			Qop: "auth-int",
+			Nc:  "00000002",
+		},
+	},
+}
+
+func TestParseAuthenticationInfo(t *testing.T) {
+	for i, v := range authInfoTests {
+		info, err := parseAuthenticationInfo(v.Header)
+		if err != nil {
+			t.Errorf("failed authInfoTests[%d]: %v", i, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(*info, v.Parsed) {
+			t.Errorf("authInfoTests[%d]: not DeepEqual:\n%v\n%v\n", i, *info, v.Parsed)
+		}
+	}
+}
+
+func TestDigestParams(t *testing.T) {
+	auth := `Digest username="Mufasa", realm="a \"quoted\", realm", nonce="n", uri="/a?b=c,d", qop=auth, nc=00000001, cnonce="0a4f113b", userhash=true`
+
+	expected := map[string]string{
+		"username": "Mufasa",
+		"realm":    `a \"quoted\", realm`,
+		"nonce":    "n",
+		"uri":      "/a?b=c,d",
+		"qop":      "auth",
+		"nc":       "00000001",
+		"cnonce":   "0a4f113b",
+		"userhash": "true",
+	}
+
+	if params := digestParams(auth); !reflect.DeepEqual(params, expected) {
+		t.Errorf("expected %v, got %v", expected, params)
+	}
+}
+
+// TestVerify checks the rspauth is computed from the qop, nc and
+// cnonce sent with the request, not those echoed by the server.
+func TestVerify(t *testing.T) {
+	kd := func(s ...string) string {
+		return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(s, ":"))))
+	}
+	ha1 := kd("Mufasa", "testrealm@host.com", "Circle Of Life")
+	rspauth := func(nc, cnonce string) string {
+		return kd(ha1, "nonce-1", nc, cnonce, "auth", kd("", "/dir/index.html"))
+	}
+
+	tests := []struct {
+		Info AuthenticationInfo
+		OK   bool
+	}{
+		{AuthenticationInfo{Qop: "auth", Rspauth: rspauth("00000001", "0a4f113b"), Cnonce: "0a4f113b", Nc: "00000001"}, true},
+		{AuthenticationInfo{Rspauth: rspauth("00000001", "0a4f113b")}, true},
+		{AuthenticationInfo{Qop: "auth", Rspauth: rspauth("00000002", "0a4f113b"), Cnonce: "0a4f113b", Nc: "00000002"}, false},
+		{AuthenticationInfo{Qop: "auth", Rspauth: rspauth("00000001", "other"), Cnonce: "other", Nc: "00000001"}, false},
+		{AuthenticationInfo{Rspauth: rspauth("00000002", "0a4f113b")}, false},
+		{AuthenticationInfo{Qop: "auth-int", Rspauth: rspauth("00000001", "0a4f113b")}, false},
+	}
+
+	challenge := &Challenge{Scheme: "Digest", Realm: "testrealm@host.com", Nonce: "nonce-1", Qop: []string{"auth"}, Domain: []string{"/dir/"}}
+
+	req, err := http.NewRequest("GET", "http://host.com/dir/index.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", `Digest username="Mufasa", realm="testrealm@host.com", nonce="nonce-1", uri="/dir/index.html", qop=auth, nc=00000001, cnonce="0a4f113b", response="ignored"`)
+
+	session := NewSession(&OrderedCredentials{}, 1000, "", -1)
+	session.SetDigestCredentials(req.URL, challenge.Domain, challenge.Realm, challenge.Algorithm, "Mufasa", ha1)
+
+	for i, v := range tests {
+		info := v.Info
+		err := challenge.Verify(session, req, &http.Response{}, &info)
+		if _, failed := err.(*MutualAuthError); v.OK == failed || (err != nil && !failed) {
+			t.Errorf("%d: expected ok %t, got %v", i, v.OK, err)
+		}
+	}
+}
@@ -3,12 +3,17 @@ package httpclient
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
 	"fmt"
 	"github.com/jimrobinson/lexrec"
 	"github.com/jimrobinson/trace"
+	"hash"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 )
 
@@ -23,6 +28,18 @@ type Challenge struct {
 	Stale     bool
 	Algorithm string
 	Qop       []string
+	Charset   string
+	Userhash  bool
+
+	// Scope, Error and ErrorDescription are sent with Bearer
+	// challenges, per RFC 6750 3.
+	Scope            []string
+	Error            string
+	ErrorDescription string
+
+	// Proxy is set when the challenge was sent by a proxy via
+	// Proxy-Authenticate, and names the proxy that sent it.
+	Proxy *url.URL
 }
 
 func (challenge *Challenge) Authorization(session Session, req *http.Request) (auth string, err error) {
@@ -31,14 +48,46 @@ func (challenge *Challenge) Authorization(session Session, req *http.Request) (a
 		auth, err = challenge.Basic(session, req)
 	case "Digest":
 		auth, err = challenge.Digest(session, req)
+	case "Bearer":
+		auth, err = challenge.Bearer(session, req)
 	default:
 		err = fmt.Errorf("unrecognized authorization scheme: %s", challenge.Scheme)
 	}
 	return
 }
 
+// Answered returns a copy of the challenge suitable for caching
+// once it has been answered successfully, with the stale and error
+// conditions that prompted the answer cleared.
+func (challenge *Challenge) Answered() *Challenge {
+	answered := *challenge
+	answered.Stale = false
+	answered.Error = ""
+	answered.ErrorDescription = ""
+	return &answered
+}
+
+// origin returns the URL used to look up credentials for the
+// challenge: the proxy that sent it, if any, else the request URL.
+func (challenge *Challenge) origin(req *http.Request) *url.URL {
+	if challenge.Proxy != nil {
+		return challenge.Proxy
+	}
+	return req.URL
+}
+
+// digestURI returns the digest-uri for req.  A proxy sees the
+// absolute request-target, or the authority for CONNECT, while an
+// origin server sees only the path and query.
+func (challenge *Challenge) digestURI(req *http.Request) string {
+	if challenge.Proxy != nil {
+		return req.URL.String()
+	}
+	return req.URL.RequestURI()
+}
+
 func (challenge *Challenge) Basic(session Session, req *http.Request) (auth string, err error) {
-	username, password, err := session.Login(req.URL, challenge.Realm)
+	username, password, err := login(session, req, challenge.origin(req), challenge.Realm)
 	if err != nil {
 		return
 	}
@@ -50,13 +99,59 @@ func (challenge *Challenge) Basic(session Session, req *http.Request) (auth stri
 	return auth, nil
 }
 
-func (challenge *Challenge) Digest(session Session, req *http.Request) (auth string, err error) {
+// Bearer returns an RFC 6750 Authorization header value using a
+// token from the session TokenSource.  If the server rejected the
+// last token as invalid_token, a new token is requested.  The wait
+// for a token is abandoned when the request context is done, if the
+// session implements ContextTokenSource.
+func (challenge *Challenge) Bearer(session Session, req *http.Request) (auth string, err error) {
+	refresh := challenge.Error == "invalid_token"
 
-	username, password, err := session.Login(req.URL, challenge.Realm)
+	token, err := lookupToken(session, req, challenge.origin(req), challenge.Realm, challenge.Scope, refresh)
 	if err != nil {
 		return
 	}
 
+	auth = fmt.Sprintf("Bearer %s", token)
+
+	return auth, nil
+}
+
+func (challenge *Challenge) Digest(session Session, req *http.Request) (auth string, err error) {
+
+	newHash, ok := digestHash(challenge.Algorithm)
+	if !ok {
+		err = fmt.Errorf("unhandled algorithm: %s", challenge.Algorithm)
+		return
+	}
+
+	// if we have already cached H(A1), e.g., when answering a
+	// stale nonce or a preemptive request, we need not look up
+	// the login credentials again.
+	username, ha1 := session.DigestCredentials(challenge.origin(req), challenge.Realm, challenge.Algorithm)
+	if ha1 != "" {
+		hooksFrom(req.Context()).login(req, challenge.origin(req), challenge.Realm, username, nil)
+	} else {
+		var password string
+		username, password, err = login(session, req, challenge.origin(req), challenge.Realm)
+		if err != nil {
+			return
+		}
+
+		// RFC 7616 3.4.2 A1
+		h := newHash()
+
+		io.WriteString(h, username)
+		io.WriteString(h, ":")
+		io.WriteString(h, challenge.Realm)
+		io.WriteString(h, ":")
+		io.WriteString(h, password)
+
+		ha1 = fmt.Sprintf("%x", h.Sum(nil))
+
+		session.SetDigestCredentials(challenge.origin(req), challenge.Domain, challenge.Realm, challenge.Algorithm, username, ha1)
+	}
+
 	// client nonce
 	var cnonce string
 	cnonce, err = session.CNonce()
@@ -83,35 +178,13 @@ func (challenge *Challenge) Digest(session Session, req *http.Request) (auth str
 		nc = session.Counter(challenge.Nonce)
 	}
 
-	// RFC 2617 3.2.2.2 A1
-	var ha1 string
-
-	switch challenge.Algorithm {
-	case "", "MD5", "MD5-sess":
-		ha1 = session.DigestCredentials(req.URL)
-		if ha1 == "" {
-			h := md5.New()
-
-			io.WriteString(h, username)
-			io.WriteString(h, ":")
-			io.WriteString(h, challenge.Realm)
-			io.WriteString(h, ":")
-			io.WriteString(h, password)
+	if isSessionAlgorithm(challenge.Algorithm) {
+		// the session hash is bound to the nonce, so a new
+		// nonce requires a new session hash.
+		hsess := session.DigestSession(challenge.origin(req).Host, challenge.Nonce, challenge.Algorithm)
 
-			ha1 = fmt.Sprintf("%x", h.Sum(nil))
-
-			session.SetDigestCredentials(req.URL, challenge.Domain, ha1)
-		}
-	default:
-		err = fmt.Errorf("unhandled algorithm: %s", challenge.Algorithm)
-		return
-	}
-
-	if challenge.Algorithm == "MD5-sess" {
-		md5sess := session.DigestSession(req.Host)
-
-		if md5sess == "" {
-			h := md5.New()
+		if hsess == "" {
+			h := newHash()
 
 			io.WriteString(h, ha1)
 			io.WriteString(h, ":")
@@ -119,37 +192,37 @@ func (challenge *Challenge) Digest(session Session, req *http.Request) (auth str
 			io.WriteString(h, ":")
 			io.WriteString(h, cnonce)
 
-			md5sess = fmt.Sprintf("%x", h.Sum(nil))
-			session.SetDigestSession(req.Host, md5sess)
+			hsess = fmt.Sprintf("%x", h.Sum(nil))
+			session.SetDigestSession(challenge.origin(req).Host, challenge.Nonce, challenge.Algorithm, hsess)
 		}
 
-		ha1 = md5sess
+		ha1 = hsess
 	}
 
-	// RFC 2617 3.2.2.3 A2
+	// RFC 7616 3.4.3 A2
 	var ha2 string
 
 	if qop == "" || qop == "auth" {
 		// A2 = Method ":" digest-uri-value
 
-		h := md5.New()
+		h := newHash()
 		io.WriteString(h, req.Method)
 		io.WriteString(h, ":")
-		io.WriteString(h, req.URL.RequestURI())
+		io.WriteString(h, challenge.digestURI(req))
 
 		ha2 = fmt.Sprintf("%x", h.Sum(nil))
 
 	} else if qop == "auth-int" {
 		// A2 = Method ":" digest-uri-value ":" H(entity-body)
 
-		h := md5.New()
+		h := newHash()
 
 		io.WriteString(h, req.Method)
 		io.WriteString(h, ":")
-		io.WriteString(h, req.URL.RequestURI())
+		io.WriteString(h, challenge.digestURI(req))
 		io.WriteString(h, ":")
 
-		hb := md5.New()
+		hb := newHash()
 		if req.Body != nil {
 			prc := session.NewProxyReadCloser()
 			mw := io.MultiWriter(hb, prc)
@@ -174,13 +247,13 @@ func (challenge *Challenge) Digest(session Session, req *http.Request) (auth str
 		ha2 = fmt.Sprintf("%x", h.Sum(nil))
 	}
 
-	// RFC 2617 3.2.2.1 Request-Digest
+	// RFC 7616 3.4.1 Response
 	var digest string
 	if qop == "auth" || qop == "auth-int" {
 		// KD ( H(A1), unq(nonce-value) ":" nc-value : unq(cnonce-value) ":" unq(qop-value) : H(A2)
 		// KD (secret, data) = H (concat(secret, ":", data))
 
-		h := md5.New()
+		h := newHash()
 
 		io.WriteString(h, ha1)
 		io.WriteString(h, ":")
@@ -200,7 +273,7 @@ func (challenge *Challenge) Digest(session Session, req *http.Request) (auth str
 		// KD ( H(A1), unq(nonce-value) ":" H(A2)
 		// KD (secret, data) = H (concat(secret, ":", data))
 
-		h := md5.New()
+		h := newHash()
 
 		io.WriteString(h, ha1)
 		io.WriteString(h, ":")
@@ -214,13 +287,26 @@ func (challenge *Challenge) Digest(session Session, req *http.Request) (auth str
 	// Authorization header is built up in buf
 	buf := &bytes.Buffer{}
 
-	buf.WriteString(fmt.Sprintf(`Digest username="%s"`, username))
+	// RFC 7616 3.4.4 Username Hashing
+	if challenge.Userhash {
+		h := newHash()
+
+		io.WriteString(h, username)
+		io.WriteString(h, ":")
+		io.WriteString(h, challenge.Realm)
+
+		buf.WriteString(fmt.Sprintf(`Digest username="%x"`, h.Sum(nil)))
+	} else if needsExtValue(username) {
+		buf.WriteString(fmt.Sprintf(`Digest username*=UTF-8''%s`, encodeExtValue(username)))
+	} else {
+		buf.WriteString(fmt.Sprintf(`Digest username="%s"`, username))
+	}
 
 	buf.WriteString(fmt.Sprintf(`, realm="%s"`, challenge.Realm))
 
 	buf.WriteString(fmt.Sprintf(`, nonce="%s"`, challenge.Nonce))
 
-	buf.WriteString(fmt.Sprintf(`, uri="%s"`, req.URL.RequestURI()))
+	buf.WriteString(fmt.Sprintf(`, uri="%s"`, challenge.digestURI(req)))
 
 	if qop != "" {
 		buf.WriteString(fmt.Sprintf(`, qop=%s`, qop))
@@ -238,18 +324,136 @@ func (challenge *Challenge) Digest(session Session, req *http.Request) (auth str
 		buf.WriteString(fmt.Sprintf(`, opaque="%s"`, challenge.Opaque))
 	}
 
+	if challenge.Userhash {
+		buf.WriteString(`, userhash=true`)
+	}
+
 	auth = buf.String()
 
 	return auth, err
 }
 
+// needsExtValue reports whether username cannot be sent as a
+// quoted-string and must instead use the username* parameter.
+func needsExtValue(username string) bool {
+	for _, r := range username {
+		if r > 0x7e || r < 0x20 || r == '"' || r == '\\' {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeExtValue percent-encodes the UTF-8 bytes of s for use in an
+// RFC 5987 ext-value, leaving only attr-char unescaped.
+func encodeExtValue(s string) string {
+	buf := &bytes.Buffer{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+// digestHash returns the hash constructor for the named Digest
+// algorithm, or false if the algorithm is not supported.  An empty
+// algorithm is treated as MD5, per RFC 7616 3.3.
+func digestHash(algorithm string) (newHash func() hash.Hash, ok bool) {
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5", "MD5-SESS":
+		return md5.New, true
+	case "SHA-256", "SHA-256-SESS":
+		return sha256.New, true
+	case "SHA-512-256", "SHA-512-256-SESS":
+		return sha512.New512_256, true
+	}
+	return nil, false
+}
+
+// isSessionAlgorithm reports whether algorithm is one of the
+// "-sess" variants, for which H(A1) is bound to the server nonce.
+func isSessionAlgorithm(algorithm string) bool {
+	return strings.HasSuffix(strings.ToLower(algorithm), "-sess")
+}
+
+// digestStrength ranks Digest algorithms from weakest to strongest,
+// returning -1 for algorithms we cannot handle.
+func digestStrength(algorithm string) int {
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5", "MD5-SESS":
+		return 0
+	case "SHA-256", "SHA-256-SESS":
+		return 1
+	case "SHA-512-256", "SHA-512-256-SESS":
+		return 2
+	}
+	return -1
+}
+
+// Preferred returns a copy of challenges with the Digest challenges
+// reordered from the strongest to the weakest algorithm.  Challenges
+// for other schemes keep their original positions.
+func (challenges Challenges) Preferred() Challenges {
+	preferred := make(Challenges, len(challenges))
+	copy(preferred, challenges)
+
+	var slots []int
+	var digests digestChallenges
+	for i, v := range preferred {
+		if v.Scheme == "Digest" {
+			slots = append(slots, i)
+			digests = append(digests, v)
+		}
+	}
+
+	sort.Stable(digests)
+
+	for i, slot := range slots {
+		preferred[slot] = digests[i]
+	}
+
+	return preferred
+}
+
+// digestChallenges sorts Digest challenges by descending algorithm
+// strength.
+type digestChallenges []*Challenge
+
+func (c digestChallenges) Len() int      { return len(c) }
+func (c digestChallenges) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c digestChallenges) Less(i, j int) bool {
+	return digestStrength(c[i].Algorithm) > digestStrength(c[j].Algorithm)
+}
+
+// Authentication returns the challenges sent by an origin server in
+// the WWW-Authenticate headers of rsp.
 func Authentication(rsp *http.Response) (authentication Challenges, err error) {
+	return authenticate(rsp, "WWW-Authenticate", nil)
+}
+
+// ProxyAuthentication returns the challenges sent by proxy in the
+// Proxy-Authenticate headers of rsp.  Credentials for the challenges
+// are looked up using the root of the proxy URL.
+func ProxyAuthentication(rsp *http.Response, proxy *url.URL) (authentication Challenges, err error) {
+	root := &url.URL{Scheme: proxy.Scheme, Host: proxy.Host, Path: "/"}
+	return authenticate(rsp, "Proxy-Authenticate", root)
+}
+
+func authenticate(rsp *http.Response, header string, proxy *url.URL) (authentication Challenges, err error) {
 	var set Challenges
-	for _, v := range rsp.Header[http.CanonicalHeaderKey("WWW-Authenticate")] {
+	for _, v := range rsp.Header[http.CanonicalHeaderKey(header)] {
 		set, err = parseChallenge(v)
 		if err != nil {
 			return nil, err
 		}
+		for _, challenge := range set {
+			challenge.Proxy = proxy
+		}
 		authentication = append(authentication, set...)
 	}
 	return
@@ -281,6 +485,8 @@ func parseChallenge(challenge string) (parsed Challenges, err error) {
 			parsed = append(parsed, &Challenge{Scheme: item.Value})
 		case ItemBasic:
 			parsed = append(parsed, &Challenge{Scheme: item.Value})
+		case ItemBearer:
+			parsed = append(parsed, &Challenge{Scheme: item.Value})
 		case ItemRealm:
 			if i := len(parsed) - 1; i >= 0 {
 				parsed[i].Realm = item.Value[1 : len(item.Value)-1]
@@ -314,6 +520,26 @@ func parseChallenge(challenge string) (parsed Challenges, err error) {
 				options := strings.Split(item.Value[1:len(item.Value)-1], ",")
 				parsed[i].Qop = options
 			}
+		case ItemCharset:
+			if i := len(parsed) - 1; i >= 0 {
+				parsed[i].Charset = unquote(item.Value)
+			}
+		case ItemUserhash:
+			if i := len(parsed) - 1; i >= 0 {
+				parsed[i].Userhash = strings.ToLower(unquote(item.Value)) == "true"
+			}
+		case ItemScope:
+			if i := len(parsed) - 1; i >= 0 {
+				parsed[i].Scope = strings.Fields(unquote(item.Value))
+			}
+		case ItemBearerError:
+			if i := len(parsed) - 1; i >= 0 {
+				parsed[i].Error = unquote(item.Value)
+			}
+		case ItemErrorDescription:
+			if i := len(parsed) - 1; i >= 0 {
+				parsed[i].ErrorDescription = unquote(item.Value)
+			}
 		case ItemAuthParam:
 			if traceT {
 				trace.T(traceFn, "skipping unrecognized auth-param: %s", item.Value)
@@ -20,6 +20,29 @@ var digestChallenge1 = Challenge{
 	Opaque: "5ccc069c403ebaf9f0171e9517f40e41",
 }
 
+var digestChallenge2 = Challenge{
+	Scheme:    "Digest",
+	Realm:     "api@example.org",
+	Qop:       []string{"auth"},
+	Algorithm: "SHA-512-256",
+	Nonce:     "5TsQWLVdgBdmrQ0XsxbDODV+57QdFR34I9HAbC/RVvkK",
+	Opaque:    "HRPCssKJSGjCrkzDg8OhwpzCiGPChXYjwrI2QmXDnsOS",
+	Charset:   "UTF-8",
+	Userhash:  true,
+}
+
+var bearerChallenge = Challenge{
+	Scheme:           "Bearer",
+	Realm:            "example",
+	Error:            "invalid_token",
+	ErrorDescription: "The access token expired",
+}
+
+var bearerChallenge2 = Challenge{
+	Scheme: "Bearer",
+	Scope:  []string{"read", "write"},
+}
+
 type ParseExpect struct {
 	Challenge string
 	Parsed    []*Challenge
@@ -53,6 +76,43 @@ var parseTests = []ParseExpect{
 			&digestChallenge1,
 		},
 	},
+	{`	Digest
+			realm="api@example.org",
+			qop="auth",
+			algorithm=SHA-512-256,
+			nonce="5TsQWLVdgBdmrQ0XsxbDODV+57QdFR34I9HAbC/RVvkK",
+			opaque="HRPCssKJSGjCrkzDg8OhwpzCiGPChXYjwrI2QmXDnsOS",
+			charset=UTF-8,
+			userhash=true`,
+		[]*Challenge{
+			&digestChallenge2,
+		},
+	},
+	{`	Digest
+			realm="api@example.org",
+			qop="auth",
+			algorithm=SHA-512-256,
+			nonce="5TsQWLVdgBdmrQ0XsxbDODV+57QdFR34I9HAbC/RVvkK",
+			opaque="HRPCssKJSGjCrkzDg8OhwpzCiGPChXYjwrI2QmXDnsOS",
+			charset="UTF-8",
+			userhash="true"`,
+		[]*Challenge{
+			&digestChallenge2,
+		},
+	},
+	{`	Bearer realm="example",
+			error="invalid_token",
+			error_description="The access token expired"`,
+		[]*Challenge{
+			&bearerChallenge,
+		},
+	},
+	{`	Bearer scope="read write", Basic realm="WallyWorld"`,
+		[]*Challenge{
+			&bearerChallenge2,
+			&basicChallenge,
+		},
+	},
 }
 
 func TestParseChallenge(t *testing.T) {
@@ -104,10 +164,11 @@ func TestBasicChallenge(t *testing.T) {
 
 type testSession struct {
 	Session
+	cnonce string
 }
 
 func (ts *testSession) CNonce() (string, error) {
-	return "0a4f113b", nil
+	return ts.cnonce, nil
 }
 
 func TestDigestChallenge(t *testing.T) {
@@ -120,7 +181,7 @@ func TestDigestChallenge(t *testing.T) {
 	challenge := &digestChallenge1
 	challenge.Qop = []string{"auth"}
 
-	session := &testSession{NewSession(credentials, 1000, "", -1)}
+	session := &testSession{Session: NewSession(credentials, 1000, "", -1), cnonce: "0a4f113b"}
 
 	req, err := http.NewRequest("GET", "http://host.com/dir/index.html", nil)
 	if err != nil {
@@ -137,6 +198,117 @@ func TestDigestChallenge(t *testing.T) {
 	}
 }
 
+// digestRFC7616 holds the example from RFC 7616 3.9.1, with the
+// response expected for each algorithm.
+var digestRFC7616 = []struct {
+	Algorithm string
+	Response  string
+}{
+	{"MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+	{"SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+}
+
+func TestDigestChallengeAlgorithms(t *testing.T) {
+
+	username := "Mufasa"
+	password := "Circle of Life"
+
+	for i, v := range digestRFC7616 {
+		expected := `Digest username="Mufasa", realm="http-auth@example.org", nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", uri="/dir/index.html", qop=auth, nc=00000001, cnonce="f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", algorithm=` + v.Algorithm + `, response="` + v.Response + `", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+
+		credentials := &OrderedCredentials{[]Credential{NewCredential("example.org", "/", username, password)}}
+		challenge := &Challenge{
+			Scheme:    "Digest",
+			Realm:     "http-auth@example.org",
+			Qop:       []string{"auth"},
+			Algorithm: v.Algorithm,
+			Nonce:     "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+			Opaque:    "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS",
+		}
+
+		session := &testSession{Session: NewSession(credentials, 1000, "", -1), cnonce: "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"}
+
+		req, err := http.NewRequest("GET", "http://example.org/dir/index.html", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		auth, err := challenge.Digest(session, req)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		if auth != expected {
+			t.Errorf("%d: expected [%s], got [%s]", i, expected, auth)
+		}
+	}
+}
+
+func TestDigestChallengeUserhash(t *testing.T) {
+
+	// RFC 7616 3.9.2; the response printed in the RFC is in error,
+	// the value below was computed independently.
+	username := "J\u00e4s\u00f8n Doe"
+	password := "Secret, or not?"
+
+	tests := []struct {
+		Userhash bool
+		Username string
+	}{
+		{true, `username="793263caabb707a56211940d90411ea4a575adeccb7e360aeb624ed06ece9b0b"`},
+		{false, `username*=UTF-8''J%C3%A4s%C3%B8n%20Doe`},
+	}
+
+	for i, v := range tests {
+		expected := `Digest ` + v.Username + `, realm="api@example.org", nonce="5TsQWLVdgBdmrQ0XsxbDODV+57QdFR34I9HAbC/RVvkK", uri="/doe.json", qop=auth, nc=00000001, cnonce="NTg6RKcb9boFIAS3KrFK9BGeh+iDa/sm6jUMp2wds69v", algorithm=SHA-512-256, response="3798d4131c277846293534c3edc11bd8a5e4cdcbff78b05db9d95eeb1cec68a5", opaque="HRPCssKJSGjCrkzDg8OhwpzCiGPChXYjwrI2QmXDnsOS"`
+		if v.Userhash {
+			expected += `, userhash=true`
+		}
+
+		credentials := &OrderedCredentials{[]Credential{NewCredential("example.org", "/", username, password)}}
+		challenge := digestChallenge2
+		challenge.Userhash = v.Userhash
+
+		session := &testSession{Session: NewSession(credentials, 1000, "", -1), cnonce: "NTg6RKcb9boFIAS3KrFK9BGeh+iDa/sm6jUMp2wds69v"}
+
+		req, err := http.NewRequest("GET", "http://example.org/doe.json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		auth, err := challenge.Digest(session, req)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		if auth != expected {
+			t.Errorf("%d: expected [%s], got [%s]", i, expected, auth)
+		}
+	}
+}
+
+func TestChallengesPreferred(t *testing.T) {
+	challenges := Challenges{
+		&basicChallenge,
+		&Challenge{Scheme: "Digest", Algorithm: "MD5"},
+		&Challenge{Scheme: "Digest", Algorithm: "SHA-256"},
+		&Challenge{Scheme: "Digest", Algorithm: "SHA-512-256"},
+	}
+
+	expected := []string{"", "SHA-512-256", "SHA-256", "MD5"}
+
+	preferred := challenges.Preferred()
+	for i, v := range preferred {
+		if v.Algorithm != expected[i] {
+			t.Errorf("%d: expected algorithm %q, got %q", i, expected[i], v.Algorithm)
+		}
+	}
+
+	if preferred[0] != &basicChallenge {
+		t.Errorf("expected Basic challenge to keep its position")
+	}
+}
+
 func BenchmarkParseChallenge(b *testing.B) {
 	buf := &bytes.Buffer{}
 	for i, v := range parseTests {
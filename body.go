@@ -0,0 +1,127 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// deadlineReadCloser enforces the deadline of the request context on
+// reads of a response body, along with an optional idle timeout that
+// is reset by each successful Read.  Cancelling the request context
+// tears down the connection, unblocking any Read in progress.
+type deadlineReadCloser struct {
+	rc     io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelFunc
+	req    *http.Request
+	start  time.Time
+
+	idle      time.Duration
+	timer     *time.Timer
+	mu        sync.Mutex
+	idleFired bool
+	reported  bool
+}
+
+// newDeadlineReadCloser wraps rc, the body of the response to req
+// made with ctx at start.  cancel is called when the body is closed
+// or a timeout expires.
+func newDeadlineReadCloser(ctx context.Context, cancel context.CancelFunc, req *http.Request, start time.Time, rc io.ReadCloser, idle time.Duration) *deadlineReadCloser {
+	d := &deadlineReadCloser{
+		rc:     rc,
+		ctx:    ctx,
+		cancel: cancel,
+		req:    req,
+		start:  start,
+		idle:   idle,
+	}
+	if idle > 0 {
+		d.timer = time.AfterFunc(idle, d.idleExpired)
+	}
+	return d
+}
+
+func (d *deadlineReadCloser) idleExpired() {
+	d.mu.Lock()
+	d.idleFired = true
+	d.mu.Unlock()
+	d.cancel()
+}
+
+// timedOut returns the error to report for a read that failed or
+// was refused because a timeout expired, or nil if none has.  The
+// first such error is reported to the request Hooks and Telemetry.
+func (d *deadlineReadCloser) timedOut() error {
+	d.mu.Lock()
+	idleFired := d.idleFired
+	d.mu.Unlock()
+
+	var err *TimeoutError
+	if idleFired {
+		err = &TimeoutError{
+			URL:     d.req.URL.String(),
+			Phase:   PhaseBody,
+			Elapsed: time.Since(d.start),
+			Err:     fmt.Errorf("no data read for %s", d.idle),
+		}
+	} else if deadline, ok := d.ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		d.cancel()
+		err = &TimeoutError{
+			URL:     d.req.URL.String(),
+			Phase:   PhaseBody,
+			Elapsed: time.Since(d.start),
+			Err:     context.DeadlineExceeded,
+		}
+	} else {
+		return nil
+	}
+
+	d.mu.Lock()
+	report := !d.reported
+	d.reported = true
+	d.mu.Unlock()
+
+	if report {
+		hooksFrom(d.ctx).timeout(d.req, err)
+		telemetryFrom(d.ctx).timeout(d.req, err)
+	}
+
+	return err
+}
+
+func (d *deadlineReadCloser) Read(p []byte) (n int, err error) {
+	if err = d.timedOut(); err != nil {
+		return 0, err
+	}
+
+	n, err = d.rc.Read(p)
+
+	if err != nil && err != io.EOF {
+		if terr := d.timedOut(); terr != nil {
+			return n, terr
+		}
+	}
+
+	if d.timer != nil {
+		if err != nil {
+			d.timer.Stop()
+		} else if n > 0 {
+			d.timer.Reset(d.idle)
+		}
+	}
+
+	return
+}
+
+func (d *deadlineReadCloser) Close() error {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	err := d.rc.Close()
+	d.cancel()
+	return err
+}
@@ -0,0 +1,94 @@
+package httpclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trickleTestHandler writes a chunk of the response body every
+// interval, stalling for stall after the count'th chunk.
+type trickleTestHandler struct {
+	interval time.Duration
+	count    int
+	stall    time.Duration
+}
+
+func (h *trickleTestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	for i := 0; i < h.count; i++ {
+		fmt.Fprintf(w, "chunk %d\n", i)
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(h.interval):
+		case <-req.Context().Done():
+			return
+		}
+	}
+	if h.stall > 0 {
+		select {
+		case <-time.After(h.stall):
+		case <-req.Context().Done():
+			return
+		}
+	}
+	fmt.Fprintf(w, "done\n")
+}
+
+func TestBodyTimeout(t *testing.T) {
+	tests := []struct {
+		Handler *trickleTestHandler
+		Timeout time.Duration
+		Idle    time.Duration
+		Err     string
+	}{
+		// an absolute timeout expires while the body trickles in
+		{&trickleTestHandler{interval: 50 * time.Millisecond, count: 20}, 300 * time.Millisecond, 0, "context deadline exceeded"},
+		// an idle timeout is reset by each chunk
+		{&trickleTestHandler{interval: 50 * time.Millisecond, count: 8}, 0, 200 * time.Millisecond, ""},
+		// an idle timeout expires when the body stalls
+		{&trickleTestHandler{interval: 50 * time.Millisecond, count: 2, stall: 2 * time.Second}, 0, 200 * time.Millisecond, "no data read"},
+	}
+
+	for i, v := range tests {
+		server := httptest.NewServer(v.Handler)
+
+		client := NewClient(v.Timeout)
+		client.BodyIdleTimeout = v.Idle
+
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+
+		rsp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		b, err := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+
+		if v.Err == "" {
+			if err != nil {
+				t.Errorf("%d: %v", i, err)
+			} else if !strings.HasSuffix(string(b), "done\n") {
+				t.Errorf("%d: expected the complete body, got %q", i, string(b))
+			}
+		} else if terr, ok := err.(*TimeoutError); !ok || terr.Phase != PhaseBody || !strings.Contains(err.Error(), v.Err) {
+			t.Errorf("%d: expected a body TimeoutError containing %q, got %v", i, v.Err, err)
+		} else if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%d: expected the read to fail promptly, took %s", i, elapsed)
+		}
+
+		server.CloseClientConnections()
+		server.Close()
+	}
+}
@@ -0,0 +1,301 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is matched, via errors.Is, by the *CircuitOpenError
+// returned for a request refused by an open circuit breaker.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+const (
+	DefaultCircuitFailures     = 5
+	DefaultCircuitWindow       = 10 * time.Second
+	DefaultCircuitMinRequests  = 10
+	DefaultCircuitOpenTimeout  = 30 * time.Second
+	DefaultCircuitHalfOpenReqs = 1
+)
+
+// CircuitState is the state of the circuit for a host.
+type CircuitState int
+
+const (
+	// CircuitClosed passes requests through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen refuses requests until OpenTimeout has passed
+	CircuitOpen
+	// CircuitHalfOpen passes a limited number of probe requests,
+	// closing the circuit if they succeed
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitOpenError is returned when a request is refused because the
+// circuit for its host is open.
+type CircuitOpenError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for %s is open until %s", e.Host, e.Until.Format(time.RFC3339))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitBreaker tracks the outcome of requests per host, and opens
+// the circuit for a host that is failing so that requests to it fail
+// fast rather than waiting out a timeout.  The circuit opens after
+// ConsecutiveFailures failures in a row, or, if FailureRate is set,
+// when at least MinRequests requests within the sliding Window have
+// failed at that rate.  After OpenTimeout the circuit is half-open,
+// and HalfOpenRequests probe requests decide whether it closes or
+// opens again.
+//
+// A request fails if IsFailure says so, by default when it returned
+// an error or a 5xx status.  OnStateChange, if set, is called after
+// each change of state.  Zero values select the defaults, and Now may
+// be replaced to control the clock.
+type CircuitBreaker struct {
+	ConsecutiveFailures int
+	FailureRate         float64
+	Window              time.Duration
+	MinRequests         int
+	OpenTimeout         time.Duration
+	HalfOpenRequests    int
+
+	IsFailure     func(rsp *http.Response, err error) bool
+	OnStateChange func(host string, from, to CircuitState)
+	Now           func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+// circuit is the state of the circuit for one host
+type circuit struct {
+	state    CircuitState
+	failures int
+	opened   time.Time
+	probes   int
+	outcomes []outcome
+}
+
+// outcome records when a request completed, and whether it failed
+type outcome struct {
+	at     time.Time
+	failed bool
+}
+
+// WithCircuitBreaker sets the circuit breaker consulted before each
+// request is sent.
+func WithCircuitBreaker(breaker *CircuitBreaker) Option {
+	return func(hr *Client) {
+		hr.Breaker = breaker
+	}
+}
+
+func (b *CircuitBreaker) now() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+	return time.Now()
+}
+
+// State returns the state of the circuit for host
+func (b *CircuitBreaker) State(host string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.hosts[host]
+	if !ok {
+		return CircuitClosed
+	}
+	if c.state == CircuitOpen && !b.now().Before(c.opened.Add(b.openTimeout())) {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+// allow reports whether a request to host may be sent.  If it may,
+// done must be called with the outcome.
+func (b *CircuitBreaker) allow(host string) (done func(rsp *http.Response, err error), err error) {
+	b.mu.Lock()
+
+	if b.hosts == nil {
+		b.hosts = make(map[string]*circuit)
+	}
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{}
+		b.hosts[host] = c
+	}
+
+	var changed func()
+
+	if c.state == CircuitOpen {
+		until := c.opened.Add(b.openTimeout())
+		if b.now().Before(until) {
+			b.mu.Unlock()
+			return nil, &CircuitOpenError{Host: host, Until: until}
+		}
+		changed = b.transition(host, c, CircuitHalfOpen)
+	}
+
+	probe := c.state == CircuitHalfOpen
+	if probe {
+		max := b.HalfOpenRequests
+		if max <= 0 {
+			max = DefaultCircuitHalfOpenReqs
+		}
+		if c.probes >= max {
+			b.mu.Unlock()
+			return nil, &CircuitOpenError{Host: host, Until: b.now()}
+		}
+		c.probes++
+	}
+
+	b.mu.Unlock()
+
+	if changed != nil {
+		changed()
+	}
+
+	done = func(rsp *http.Response, err error) {
+		b.record(host, c, probe, b.failed(rsp, err))
+	}
+
+	return done, nil
+}
+
+func (b *CircuitBreaker) failed(rsp *http.Response, err error) bool {
+	if b.IsFailure != nil {
+		return b.IsFailure(rsp, err)
+	}
+	return err != nil || rsp.StatusCode >= 500
+}
+
+// record updates the circuit for host with the outcome of a request
+func (b *CircuitBreaker) record(host string, c *circuit, probe bool, failed bool) {
+	b.mu.Lock()
+
+	now := b.now()
+	var changed func()
+
+	if probe {
+		c.probes--
+	}
+
+	switch c.state {
+	case CircuitHalfOpen:
+		if failed {
+			changed = b.open(host, c, now)
+		} else {
+			c.failures = 0
+			c.outcomes = nil
+			changed = b.transition(host, c, CircuitClosed)
+		}
+	case CircuitClosed:
+		if failed {
+			c.failures++
+		} else {
+			c.failures = 0
+		}
+		if b.FailureRate > 0 {
+			c.outcomes = append(c.outcomes, outcome{at: now, failed: failed})
+		}
+		if b.trip(c, now) {
+			changed = b.open(host, c, now)
+		}
+	}
+
+	b.mu.Unlock()
+
+	if changed != nil {
+		changed()
+	}
+}
+
+// trip reports whether the closed circuit c should open
+func (b *CircuitBreaker) trip(c *circuit, now time.Time) bool {
+	consecutive := b.ConsecutiveFailures
+	if consecutive <= 0 && b.FailureRate <= 0 {
+		consecutive = DefaultCircuitFailures
+	}
+	if consecutive > 0 && c.failures >= consecutive {
+		return true
+	}
+
+	if b.FailureRate <= 0 {
+		return false
+	}
+
+	window := b.Window
+	if window <= 0 {
+		window = DefaultCircuitWindow
+	}
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(c.outcomes) && !c.outcomes[i].at.After(cutoff) {
+		i++
+	}
+	c.outcomes = c.outcomes[i:]
+
+	min := b.MinRequests
+	if min <= 0 {
+		min = DefaultCircuitMinRequests
+	}
+	if len(c.outcomes) < min {
+		return false
+	}
+
+	failures := 0
+	for _, v := range c.outcomes {
+		if v.failed {
+			failures++
+		}
+	}
+	return float64(failures)/float64(len(c.outcomes)) >= b.FailureRate
+}
+
+func (b *CircuitBreaker) open(host string, c *circuit, now time.Time) func() {
+	c.opened = now
+	c.failures = 0
+	c.outcomes = nil
+	return b.transition(host, c, CircuitOpen)
+}
+
+// transition moves c to state, returning a function that reports the
+// change to OnStateChange, to be called once the lock is released.
+func (b *CircuitBreaker) transition(host string, c *circuit, state CircuitState) func() {
+	from := c.state
+	c.state = state
+	if b.OnStateChange == nil || from == state {
+		return nil
+	}
+	return func() { b.OnStateChange(host, from, state) }
+}
+
+func (b *CircuitBreaker) openTimeout() time.Duration {
+	if b.OpenTimeout > 0 {
+		return b.OpenTimeout
+	}
+	return DefaultCircuitOpenTimeout
+}
@@ -0,0 +1,155 @@
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerConsecutive(t *testing.T) {
+	now := time.Now()
+
+	var changes []CircuitState
+	b := &CircuitBreaker{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         time.Minute,
+		Now:                 func() time.Time { return now },
+		OnStateChange: func(host string, from, to CircuitState) {
+			changes = append(changes, to)
+		},
+	}
+
+	ok := &http.Response{StatusCode: http.StatusOK}
+	fail := &http.Response{StatusCode: http.StatusBadGateway}
+
+	tests := []struct {
+		Advance time.Duration
+		Rsp     *http.Response
+		Open    bool
+		State   CircuitState
+	}{
+		{0, fail, false, CircuitClosed},
+		{0, ok, false, CircuitClosed},
+		{0, fail, false, CircuitClosed},
+		{0, fail, false, CircuitClosed},
+		{0, fail, false, CircuitOpen},
+		// requests fail fast while open
+		{time.Second, nil, true, CircuitOpen},
+		// a failed probe reopens the circuit
+		{time.Minute, fail, false, CircuitOpen},
+		{time.Second, nil, true, CircuitOpen},
+		// a successful probe closes it
+		{time.Minute, ok, false, CircuitClosed},
+	}
+
+	for i, v := range tests {
+		now = now.Add(v.Advance)
+
+		done, err := b.allow("example.com")
+		if v.Open {
+			if !errors.Is(err, ErrCircuitOpen) {
+				t.Errorf("%d: expected ErrCircuitOpen, got %v", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("%d: %v", i, err)
+		} else {
+			done(v.Rsp, nil)
+		}
+
+		if s := b.State("example.com"); s != v.State {
+			t.Errorf("%d: expected state %s, got %s", i, v.State, s)
+		}
+	}
+
+	expected := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected state changes %v, got %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("%d: expected state change to %s, got %s", i, expected[i], changes[i])
+		}
+	}
+
+	if s := b.State("other.example.com"); s != CircuitClosed {
+		t.Errorf("expected other hosts to be unaffected, got %s", s)
+	}
+}
+
+func TestCircuitBreakerRate(t *testing.T) {
+	now := time.Now()
+
+	b := &CircuitBreaker{
+		FailureRate: 0.5,
+		Window:      10 * time.Second,
+		MinRequests: 4,
+		Now:         func() time.Time { return now },
+	}
+
+	record := func(failed bool) {
+		done, err := b.allow("example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failed {
+			done(nil, errors.New("connection refused"))
+		} else {
+			done(&http.Response{StatusCode: http.StatusOK}, nil)
+		}
+	}
+
+	// failures that have aged out of the window do not count
+	record(true)
+	record(true)
+	now = now.Add(20 * time.Second)
+	record(false)
+	record(true)
+	record(false)
+	if s := b.State("example.com"); s != CircuitClosed {
+		t.Fatalf("expected closed with too few requests in the window, got %s", s)
+	}
+
+	record(true)
+	if s := b.State("example.com"); s != CircuitOpen {
+		t.Fatalf("expected open at a 50%% failure rate, got %s", s)
+	}
+}
+
+func TestDoCircuitBreaker(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := New(
+		WithCircuitBreaker(&CircuitBreaker{ConsecutiveFailures: 2}),
+		WithRetry(&RetryPolicy{
+			MaxAttempts: 5,
+			jitter:      func(d time.Duration) time.Duration { return 0 },
+		}),
+	)
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Do(req)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if count != 2 {
+		t.Errorf("expected the circuit to open after 2 requests, got %d", count)
+	}
+}
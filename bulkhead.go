@@ -0,0 +1,189 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrBulkheadFull is matched, via errors.Is, by the *BulkheadError
+// returned for a request rejected by a Bulkhead.
+var ErrBulkheadFull = errors.New("bulkhead is full")
+
+// BulkheadError is returned when a request is rejected because the
+// requests in flight to its host are at the limit and either the
+// queue is full or the request waited too long for a place.
+type BulkheadError struct {
+	Host   string
+	Queued bool
+	Waited time.Duration
+}
+
+func (e *BulkheadError) Error() string {
+	if e.Queued {
+		return fmt.Sprintf("bulkhead for %s rejected request after waiting %s", e.Host, e.Waited)
+	}
+	return fmt.Sprintf("bulkhead for %s rejected request: queue is full", e.Host)
+}
+
+func (e *BulkheadError) Is(target error) bool {
+	return target == ErrBulkheadFull
+}
+
+// BulkheadGauge reports the use of a Bulkhead for one host
+type BulkheadGauge struct {
+	Active int
+	Queued int
+}
+
+// Bulkhead limits the number of requests in flight to each host to
+// MaxConcurrent, so that one slow backend cannot exhaust goroutines
+// and sockets.  Up to MaxQueued further requests wait for a place,
+// until MaxWait, if set, or the request deadline passes; others are
+// rejected at once.  Key maps a request to its host, by default
+// LimitByHost.
+type Bulkhead struct {
+	MaxConcurrent int
+	MaxQueued     int
+	MaxWait       time.Duration
+	Key           func(req *http.Request) string
+
+	mu    sync.Mutex
+	hosts map[string]*compartment
+}
+
+// compartment is the semaphore for one host
+type compartment struct {
+	slots  chan struct{}
+	active int
+	queued int
+}
+
+// NewBulkhead returns a Bulkhead allowing maxConcurrent requests in
+// flight to each host, with up to maxQueued waiting.
+func NewBulkhead(maxConcurrent, maxQueued int) *Bulkhead {
+	return &Bulkhead{MaxConcurrent: maxConcurrent, MaxQueued: maxQueued}
+}
+
+// WithBulkhead sets the bulkhead limiting requests in flight per host
+func WithBulkhead(bulkhead *Bulkhead) Option {
+	return func(hr *Client) {
+		hr.Bulkhead = bulkhead
+	}
+}
+
+// Gauges returns the current use of the bulkhead for each host
+func (b *Bulkhead) Gauges() map[string]BulkheadGauge {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	gauges := make(map[string]BulkheadGauge, len(b.hosts))
+	for host, c := range b.hosts {
+		gauges[host] = BulkheadGauge{Active: c.active, Queued: c.queued}
+	}
+	return gauges
+}
+
+func (b *Bulkhead) compartment(key string) *compartment {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.hosts == nil {
+		b.hosts = make(map[string]*compartment)
+	}
+	c, ok := b.hosts[key]
+	if !ok {
+		max := b.MaxConcurrent
+		if max <= 0 {
+			max = 1
+		}
+		c = &compartment{slots: make(chan struct{}, max)}
+		b.hosts[key] = c
+	}
+	return c
+}
+
+// acquire waits for a place for req, returning a function that gives
+// it up.
+func (b *Bulkhead) acquire(ctx context.Context, req *http.Request) (release func(), err error) {
+	key := LimitByHost(req)
+	if b.Key != nil {
+		key = b.Key(req)
+	}
+
+	c := b.compartment(key)
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			b.mu.Lock()
+			c.active--
+			b.mu.Unlock()
+			<-c.slots
+		})
+	}
+
+	select {
+	case c.slots <- struct{}{}:
+		b.mu.Lock()
+		c.active++
+		b.mu.Unlock()
+		return release, nil
+	default:
+	}
+
+	b.mu.Lock()
+	if c.queued >= b.MaxQueued {
+		b.mu.Unlock()
+		return nil, &BulkheadError{Host: key}
+	}
+	c.queued++
+	b.mu.Unlock()
+
+	start := time.Now()
+
+	var timeout <-chan time.Time
+	if b.MaxWait > 0 {
+		timer := time.NewTimer(b.MaxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case c.slots <- struct{}{}:
+		b.mu.Lock()
+		c.queued--
+		c.active++
+		b.mu.Unlock()
+		return release, nil
+	case <-timeout:
+		err = &BulkheadError{Host: key, Queued: true, Waited: time.Since(start)}
+	case <-ctx.Done():
+		err = ctx.Err()
+		if err == context.DeadlineExceeded {
+			err = &BulkheadError{Host: key, Queued: true, Waited: time.Since(start)}
+		}
+	}
+
+	b.mu.Lock()
+	c.queued--
+	b.mu.Unlock()
+
+	return nil, err
+}
+
+// releaseReadCloser calls release when the wrapped body is closed
+type releaseReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (rc *releaseReadCloser) Close() error {
+	err := rc.ReadCloser.Close()
+	rc.release()
+	return err
+}
@@ -0,0 +1,85 @@
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBulkhead(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	bulkhead := NewBulkhead(2, 1)
+	bulkhead.MaxWait = 200 * time.Millisecond
+
+	client := New(WithBulkhead(bulkhead), WithTimeouts(Timeouts{Total: 5 * time.Second}))
+
+	host := server.Listener.Addr().String()
+
+	gauge := func() BulkheadGauge {
+		return bulkhead.Gauges()[host]
+	}
+
+	await := func(expected BulkheadGauge) {
+		for i := 0; i < 100 && gauge() != expected; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if g := gauge(); g != expected {
+			t.Fatalf("expected gauge %+v, got %+v", expected, g)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			rsp, err := client.Do(req)
+			if err == nil {
+				rsp.Body.Close()
+			}
+			errs <- err
+		}()
+		if i < 2 {
+			await(BulkheadGauge{Active: i + 1})
+		}
+	}
+
+	// two requests are in flight and one waits
+	await(BulkheadGauge{Active: 2, Queued: 1})
+
+	// with the queue full, a fourth is rejected at once
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	_, err := client.Do(req)
+	var berr *BulkheadError
+	if !errors.As(err, &berr) || berr.Queued {
+		t.Errorf("expected a queue full BulkheadError, got %v", err)
+	}
+
+	// the waiting request gives up after MaxWait
+	err = <-errs
+	if !errors.Is(err, ErrBulkheadFull) || !errors.As(err, &berr) || !berr.Queued {
+		t.Errorf("expected a BulkheadError after waiting, got %v", err)
+	}
+	await(BulkheadGauge{Active: 2})
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	await(BulkheadGauge{})
+}
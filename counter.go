@@ -15,6 +15,9 @@ type NonceCounter struct {
 
 	// cap specifies the capacity of this cache
 	cap int
+
+	// evictions counts the nonces dropped to make room
+	evictions uint64
 }
 
 // NewNonceCounter returns a new NonceCounter with
@@ -38,6 +41,7 @@ func (nc *NonceCounter) Next(nonce string) int {
 		nc.ll.MoveToFront(p)
 		v := p.Value.(item)
 		v.n = v.n + 1
+		p.Value = v
 		return v.n
 	}
 
@@ -45,6 +49,7 @@ func (nc *NonceCounter) Next(nonce string) int {
 		p = nc.ll.Back()
 		nc.ll.Remove(p)
 		delete(nc.m, p.Value.(item).k)
+		nc.evictions++
 	}
 
 	v := item{nonce, 1}
@@ -54,6 +59,12 @@ func (nc *NonceCounter) Next(nonce string) int {
 	return v.n
 }
 
+// Evictions returns the number of nonces dropped from the cache to
+// make room for another.
+func (nc *NonceCounter) Evictions() uint64 {
+	return nc.evictions
+}
+
 type item struct {
 	k string
 	n int
@@ -0,0 +1,11 @@
+// Package httpclient wraps the net/http Client with timeouts, and
+// answers Basic, Digest and Bearer authentication challenges using a
+// Session.
+//
+// Compatibility: the Session interface has changed since the first
+// release.  SetAuthorization, Authorization, SetDigestCredentials,
+// DigestCredentials, SetDigestSession and DigestSession take new
+// arguments, and Token, SetProxyAuthorization and ProxyAuthorization
+// have been added, so a Session implemented outside this package no
+// longer compiles until it is updated.  See Session for details.
+package httpclient
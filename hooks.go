@@ -0,0 +1,170 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Hooks receives the events of each request sent by a Client, e.g.,
+// to log the progress of an authentication exchange.  Any of the
+// callbacks may be nil.  Each is passed the time elapsed since the
+// logical request started, when Do or DoAuth was called, so that
+// retries, redirects and challenges fall on one timeline.  Callbacks
+// are made synchronously, from the goroutine sending the request or
+// reading its body, and should return quickly.
+//
+// The CONNECT request for an https tunnel is sent by the Transport as
+// it dials, and is not itself reported.  A proxy challenge to it is
+// reported with the request being tunneled, and the lookups made to
+// answer the challenge are passed the CONNECT request.
+type Hooks struct {
+	// RequestStart is called once when a request is started.
+	RequestStart func(req *http.Request, start time.Time)
+
+	// AuthChallenge is called when a response challenges the
+	// request, with status 401 or 407, or when a proxy challenges
+	// the CONNECT request for an https tunnel.
+	AuthChallenge func(req *http.Request, status int, challenges Challenges, elapsed time.Duration)
+
+	// CredentialLookup is called when the Session has been asked
+	// for a login to answer a challenge, or for the Digest
+	// credentials cached from an earlier login.  The password is
+	// never passed.
+	CredentialLookup func(req *http.Request, uri *url.URL, realm, username string, err error, elapsed time.Duration)
+
+	// TokenLookup is called when the Session has been asked for a
+	// token to answer a Bearer challenge.  The token is never
+	// passed.
+	TokenLookup func(req *http.Request, uri *url.URL, realm string, scope []string, refresh bool, err error, elapsed time.Duration)
+
+	// RetryScheduled is called before waiting delay to send the
+	// attempt following attempt.
+	RetryScheduled func(req *http.Request, attempt int, delay, elapsed time.Duration)
+
+	// TimeoutFired is called when a timeout expires, whether
+	// waiting on the response or reading its body.
+	TimeoutFired func(req *http.Request, err *TimeoutError, elapsed time.Duration)
+
+	// ResponseComplete is called when the response body is closed,
+	// or when the request fails with err.
+	ResponseComplete func(req *http.Request, rsp *http.Response, err error, elapsed time.Duration)
+}
+
+// WithHooks sets the Hooks told of the progress of each request.
+func WithHooks(hooks *Hooks) Option {
+	return func(hr *Client) {
+		hr.Hooks = hooks
+	}
+}
+
+type hookTraceKey struct{}
+
+// hookTrace carries the Hooks of a logical request, and the time it
+// started, in the request context.
+type hookTrace struct {
+	hooks *Hooks
+	start time.Time
+}
+
+// start returns ctx carrying a hookTrace for req, after calling
+// RequestStart.  It returns ctx unchanged if hooks is nil.
+func (hooks *Hooks) start(ctx context.Context, req *http.Request) (context.Context, *hookTrace) {
+	if hooks == nil {
+		return ctx, nil
+	}
+	h := &hookTrace{hooks: hooks, start: time.Now()}
+	if hooks.RequestStart != nil {
+		hooks.RequestStart(req, h.start)
+	}
+	return context.WithValue(ctx, hookTraceKey{}, h), h
+}
+
+// hooksFrom returns the hookTrace carried by ctx, or nil.  Each of
+// the hookTrace methods is a no-op on nil.
+func hooksFrom(ctx context.Context) *hookTrace {
+	h, _ := ctx.Value(hookTraceKey{}).(*hookTrace)
+	return h
+}
+
+func (h *hookTrace) challenge(req *http.Request, status int, challenges Challenges) {
+	if h != nil && h.hooks.AuthChallenge != nil {
+		h.hooks.AuthChallenge(req, status, challenges, time.Since(h.start))
+	}
+}
+
+func (h *hookTrace) login(req *http.Request, uri *url.URL, realm, username string, err error) {
+	if h != nil && h.hooks.CredentialLookup != nil {
+		h.hooks.CredentialLookup(req, uri, realm, username, err, time.Since(h.start))
+	}
+}
+
+func (h *hookTrace) token(req *http.Request, uri *url.URL, realm string, scope []string, refresh bool, err error) {
+	if h != nil && h.hooks.TokenLookup != nil {
+		h.hooks.TokenLookup(req, uri, realm, scope, refresh, err, time.Since(h.start))
+	}
+}
+
+func (h *hookTrace) retry(req *http.Request, attempt int, delay time.Duration) {
+	if h != nil && h.hooks.RetryScheduled != nil {
+		h.hooks.RetryScheduled(req, attempt, delay, time.Since(h.start))
+	}
+}
+
+func (h *hookTrace) timeout(req *http.Request, err *TimeoutError) {
+	if h != nil && h.hooks.TimeoutFired != nil {
+		h.hooks.TimeoutFired(req, err, time.Since(h.start))
+	}
+}
+
+// complete arranges for ResponseComplete to be called once rsp is
+// finished with: immediately if there is no response, otherwise when
+// the response body is closed.
+func (h *hookTrace) complete(req *http.Request, rsp *http.Response, err error) (*http.Response, error) {
+	if h == nil || h.hooks.ResponseComplete == nil {
+		return rsp, err
+	}
+	if err != nil || rsp == nil {
+		h.hooks.ResponseComplete(req, rsp, err, time.Since(h.start))
+		return rsp, err
+	}
+	rsp.Body = &completeReadCloser{ReadCloser: rsp.Body, h: h, req: req, rsp: rsp}
+	return rsp, nil
+}
+
+// completeReadCloser calls ResponseComplete when the wrapped body is
+// first closed.
+type completeReadCloser struct {
+	io.ReadCloser
+	h    *hookTrace
+	req  *http.Request
+	rsp  *http.Response
+	once sync.Once
+}
+
+func (rc *completeReadCloser) Close() error {
+	err := rc.ReadCloser.Close()
+	rc.once.Do(func() {
+		rc.h.hooks.ResponseComplete(rc.req, rc.rsp, nil, time.Since(rc.h.start))
+	})
+	return err
+}
+
+// login looks up the username and password for uri and realm via
+// session, reporting the lookup to the Hooks of req.
+func login(session Session, req *http.Request, uri *url.URL, realm string) (username, password string, err error) {
+	username, password, err = session.Login(uri, realm)
+	hooksFrom(req.Context()).login(req, uri, realm, username, err)
+	return
+}
+
+// lookupToken looks up a token for uri, realm and scope via session,
+// reporting the lookup to the Hooks of req.
+func lookupToken(session Session, req *http.Request, uri *url.URL, realm string, scope []string, refresh bool) (token string, err error) {
+	token, err = tokenContext(req.Context(), session, uri, realm, scope, refresh)
+	hooksFrom(req.Context()).token(req, uri, realm, scope, refresh, err)
+	return
+}
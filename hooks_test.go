@@ -0,0 +1,219 @@
+package httpclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hooksRecorder records the events reported to its Hooks
+type hooksRecorder struct {
+	sync.Mutex
+	events  []string
+	elapsed []time.Duration
+}
+
+func (r *hooksRecorder) record(elapsed time.Duration, format string, args ...interface{}) {
+	r.Lock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+	r.elapsed = append(r.elapsed, elapsed)
+	r.Unlock()
+}
+
+func (r *hooksRecorder) hooks() *Hooks {
+	return &Hooks{
+		RequestStart: func(req *http.Request, start time.Time) {
+			r.record(0, "start %s %s", req.Method, req.URL.Path)
+		},
+		AuthChallenge: func(req *http.Request, status int, challenges Challenges, elapsed time.Duration) {
+			r.record(elapsed, "challenge %d %s", status, challenges[0].Scheme)
+		},
+		CredentialLookup: func(req *http.Request, uri *url.URL, realm, username string, err error, elapsed time.Duration) {
+			r.record(elapsed, "login %s %s %v", realm, username, err)
+		},
+		TokenLookup: func(req *http.Request, uri *url.URL, realm string, scope []string, refresh bool, err error, elapsed time.Duration) {
+			r.record(elapsed, "token %s %v %t %v", realm, scope, refresh, err)
+		},
+		RetryScheduled: func(req *http.Request, attempt int, delay, elapsed time.Duration) {
+			r.record(elapsed, "retry %d", attempt)
+		},
+		TimeoutFired: func(req *http.Request, err *TimeoutError, elapsed time.Duration) {
+			r.record(elapsed, "timeout %s", err.Phase)
+		},
+		ResponseComplete: func(req *http.Request, rsp *http.Response, err error, elapsed time.Duration) {
+			if err != nil {
+				r.record(elapsed, "complete error")
+				return
+			}
+			r.record(elapsed, "complete %d", rsp.StatusCode)
+		},
+	}
+}
+
+func TestHooks(t *testing.T) {
+	digest := newDigestTestHandler("nonce-1")
+	digestServer := httptest.NewServer(digest)
+	defer digestServer.Close()
+
+	flaky := &flakyTestHandler{failures: 1, status: http.StatusServiceUnavailable}
+	flakyServer := httptest.NewServer(flaky)
+	defer flakyServer.Close()
+
+	trickle := &trickleTestHandler{interval: 10 * time.Millisecond, count: 1, stall: time.Second}
+	trickleServer := httptest.NewServer(trickle)
+	defer trickleServer.Close()
+
+	credentials := &OrderedCredentials{[]Credential{NewCredential("", "", digest.username, digest.password)}}
+
+	tests := []struct {
+		URL    string
+		Events []string
+	}{
+		{digestServer.URL + "/dir/index.html", []string{
+			"start GET /dir/index.html",
+			"challenge 401 Digest",
+			"login testrealm@host.com Mufasa <nil>",
+			"complete 200",
+		}},
+		{flakyServer.URL + "/", []string{
+			"start GET /",
+			"retry 1",
+			"complete 200",
+		}},
+		{trickleServer.URL + "/", []string{
+			"start GET /",
+			"timeout response body",
+			"complete 200",
+		}},
+	}
+
+	for i, v := range tests {
+		recorder := &hooksRecorder{}
+
+		client := New(
+			WithHooks(recorder.hooks()),
+			WithTimeouts(Timeouts{BodyIdle: 100 * time.Millisecond}),
+			WithRetry(&RetryPolicy{BaseDelay: time.Millisecond}),
+		)
+		session := NewSession(credentials, 1000, "", -1)
+
+		req, err := http.NewRequest("GET", v.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := client.DoAuth(req, session)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+
+		recorder.Lock()
+		if s := strings.Join(recorder.events, "; "); s != strings.Join(v.Events, "; ") {
+			t.Errorf("%d: expected events %q, got %q", i, v.Events, recorder.events)
+		}
+		for j := 1; j < len(recorder.elapsed); j++ {
+			if recorder.elapsed[j] < recorder.elapsed[j-1] {
+				t.Errorf("%d: expected elapsed times to increase, got %v", i, recorder.elapsed)
+			}
+		}
+		recorder.Unlock()
+	}
+}
+
+// TestHooksLookups checks Bearer token lookups, and Digest challenges
+// answered from the cached H(A1), are reported.
+func TestHooksLookups(t *testing.T) {
+	digest := newDigestTestHandler("nonce-1")
+	digestServer := httptest.NewServer(digest)
+	defer digestServer.Close()
+
+	bearer := &bearerTestHandler{token: "token-1"}
+	bearerServer := httptest.NewServer(bearer)
+	defer bearerServer.Close()
+
+	credentials := &OrderedCredentials{[]Credential{NewCredential("", "", digest.username, digest.password)}}
+	tokens := TokenFunc(func(uri *url.URL, realm string, scope []string, refresh bool) (string, error) {
+		return "token-1", nil
+	})
+
+	recorder := &hooksRecorder{}
+	client := New(WithHooks(recorder.hooks()))
+	session := NewTokenSession(credentials, tokens, 1000, "", -1)
+
+	tests := []struct {
+		URL    string
+		Events []string
+	}{
+		{bearerServer.URL + "/resource", []string{
+			"start GET /resource",
+			"challenge 401 Bearer",
+			"token example [read write] false <nil>",
+			"complete 200",
+		}},
+		{digestServer.URL + "/dir/index.html", []string{
+			"start GET /dir/index.html",
+			"challenge 401 Digest",
+			"login testrealm@host.com Mufasa <nil>",
+			"complete 200",
+		}},
+		{digestServer.URL + "/dir/index.html", []string{
+			"start GET /dir/index.html",
+			"login testrealm@host.com Mufasa <nil>",
+			"complete 200",
+		}},
+	}
+
+	for i, v := range tests {
+		recorder.Lock()
+		recorder.events = nil
+		recorder.Unlock()
+
+		req, err := http.NewRequest("GET", v.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := client.DoAuth(req, session)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		rsp.Body.Close()
+
+		recorder.Lock()
+		if s := strings.Join(recorder.events, "; "); s != strings.Join(v.Events, "; ") {
+			t.Errorf("%d: expected events %q, got %q", i, v.Events, recorder.events)
+		}
+		recorder.Unlock()
+	}
+}
+
+func TestHooksError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	server.Close()
+
+	recorder := &hooksRecorder{}
+	client := New(WithHooks(recorder.hooks()))
+
+	req, err := http.NewRequest("GET", server.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Do(req)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	expected := []string{"start GET /", "complete error"}
+	if strings.Join(recorder.events, "; ") != strings.Join(expected, "; ") {
+		t.Errorf("expected events %q, got %q", expected, recorder.events)
+	}
+}
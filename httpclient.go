@@ -1,8 +1,10 @@
 package httpclient
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"time"
 )
@@ -13,19 +15,84 @@ var traceId = "github.com/jimrobinson/httpclient"
 // the read of response bodys from an http server.  It treats the
 // configured timeout as absolute, not as a deadline that resets per
 // successful read operation.
+//
+// If BodyIdleTimeout is set, reading a response body also fails if
+// no data arrives for that long, which suits long streaming
+// downloads better than an absolute timeout.
+//
+// If Signer is set, each request is signed before it is sent, after
+// DoAuth has authorized it.
+//
+// If Session is set, DoAuth uses it when called without one.
+//
+// If Retry is set, failed requests are retried as it directs.  If
+// Breaker is set, requests to a failing host fail fast.  If Limiter
+// is set, requests wait their turn under its rate limit.  If Bulkhead
+// is set, it limits the requests in flight to each host.  Middleware
+// wraps each request sent, within the above.
+//
+// If Hooks is set, it is told of the progress of each request.  If
+// Telemetry is set, it reports spans and metrics for each request.
 type Client struct {
 	http.Client
-	Transport *http.Transport
-	Timeout   time.Duration
+	Transport       *http.Transport
+	Timeout         time.Duration
+	BodyIdleTimeout time.Duration
+	Signer          Signer
+	Session         Session
+	Retry           *RetryPolicy
+	Breaker         *CircuitBreaker
+	Limiter         *RateLimiter
+	Bulkhead        *Bulkhead
+	Middleware      []Middleware
+	RedirectPolicy  *RedirectPolicy
+	Hooks           *Hooks
+	Telemetry       *Telemetry
+
+	dialer *net.Dialer
+}
+
+// Timeouts configures the time allowed for each phase of a request.
+// A zero duration imposes no limit on that phase.
+type Timeouts struct {
+	// Dial limits establishing a connection, including the DNS
+	// lookup.
+	Dial time.Duration
+
+	// TLSHandshake limits the TLS handshake of an https connection.
+	TLSHandshake time.Duration
+
+	// ResponseHeader limits the wait for response headers once the
+	// request has been written.
+	ResponseHeader time.Duration
+
+	// Total limits the request as a whole, up to the close of the
+	// response body.
+	Total time.Duration
+
+	// BodyIdle limits the wait for each read of the response body.
+	BodyIdle time.Duration
 }
 
 // NewClient returns an Client configured to timeout requests
 // that take longer than the specified timeout.
 func NewClient(timeout time.Duration) (hr *Client) {
+	return NewClientTimeouts(Timeouts{
+		ResponseHeader: timeout,
+		Total:          timeout,
+	})
+}
+
+// NewClientTimeouts returns a Client enforcing each of the specified
+// timeouts separately, so that, e.g., a slow DNS lookup and a slow
+// response body fail with a TimeoutError naming the phase at fault.
+func NewClientTimeouts(timeouts Timeouts) (hr *Client) {
+	dialer := &net.Dialer{}
 
 	transport := &http.Transport{
-		ResponseHeaderTimeout: timeout,
+		DialContext: dialer.DialContext,
 	}
+	SetProxyConnectHooks(transport)
 
 	client := http.Client{
 		Transport: transport,
@@ -34,142 +101,270 @@ func NewClient(timeout time.Duration) (hr *Client) {
 	hr = &Client{
 		Client:    client,
 		Transport: transport,
-		Timeout:   timeout,
+		dialer:    dialer,
 	}
 
+	hr.setTimeouts(timeouts)
+
 	return
 }
 
+// setTimeouts configures the Client and its Transport to enforce
+// timeouts.
+func (hr *Client) setTimeouts(timeouts Timeouts) {
+	hr.dialer.Timeout = timeouts.Dial
+	hr.Transport.TLSHandshakeTimeout = timeouts.TLSHandshake
+	hr.Transport.ResponseHeaderTimeout = timeouts.ResponseHeader
+	hr.Timeout = timeouts.Total
+	hr.BodyIdleTimeout = timeouts.BodyIdle
+}
+
 // Do sends an HTTP request and returns an HTTP response, following
 // policy (e.g. redirects, cookies, auth) as configured on the client.
 // If a non-zero timeout has been set on the Client, the request will
 // be cancled if the duration has been reached before the request has
-// completed.
+// completed.  The timeout continues to apply while the response body
+// is read, until it is closed.
 func (hr *Client) Do(req *http.Request) (rsp *http.Response, err error) {
-	rspCh := make(chan *http.Response)
-	errCh := make(chan error)
+	ctx, cancel := hr.timeoutContext(req.Context())
+	rsp, err = hr.DoContext(ctx, req)
+	return release(rsp, err, cancel)
+}
 
-	var timeoutCh <-chan time.Time
-	if hr.Timeout > 0 {
-		timeoutCh = time.After(hr.Timeout)
+// DoContext performs the same work as Do, but the request is
+// cancelled when ctx is done, rather than after the Client timeout.
+// Reads of the response body fail once the ctx deadline has passed,
+// or if BodyIdleTimeout elapses between reads, and the connection
+// is torn down.  A timeout is reported as a *TimeoutError.  If the
+// Client has a Retry policy, the request may be sent more than once.
+func (hr *Client) DoContext(ctx context.Context, req *http.Request) (rsp *http.Response, err error) {
+	ctx, hooks := hr.Hooks.start(ctx, req)
+	ctx, telemetry := hr.Telemetry.start(ctx, req)
+	rsp, err = hr.RoundTripper().RoundTrip(req.WithContext(ctx))
+	rsp, err = telemetry.complete(req, rsp, err)
+	return hooks.complete(req, rsp, err)
+}
+
+// RoundTripper returns the chain of middleware configured on the
+// Client, ending in the embedded http.Client: signing, retries, rate
+// limiting, the circuit breaker, the bulkhead, timeouts, and then any
+// Middleware, in that order, and last the Telemetry span of each
+// request sent.
+func (hr *Client) RoundTripper() http.RoundTripper {
+	return hr.roundTripper(&hr.Client, hr.Session)
+}
+
+// roundTripper returns the chain of middleware configured on the
+// Client, ending in client, with request bodies retried via session.
+func (hr *Client) roundTripper(client *http.Client, session Session) http.RoundTripper {
+	var middleware []Middleware
+	if hr.Signer != nil {
+		middleware = append(middleware, Sign(hr.Signer))
+	}
+	if hr.Retry != nil {
+		middleware = append(middleware, Retry(hr.Retry, session))
+	}
+	if hr.Limiter != nil {
+		middleware = append(middleware, Limit(hr.Limiter))
+	}
+	if hr.Breaker != nil {
+		middleware = append(middleware, Break(hr.Breaker))
+	}
+	if hr.Bulkhead != nil {
+		middleware = append(middleware, Isolate(hr.Bulkhead))
+	}
+	middleware = append(middleware, Timeout(0, hr.BodyIdleTimeout))
+	middleware = append(middleware, hr.Middleware...)
+	if hr.Telemetry != nil {
+		middleware = append(middleware, func(next http.RoundTripper) http.RoundTripper {
+			return telemetryTransport{next}
+		})
 	}
 
-	go func(req *http.Request) {
-		rsp, err := hr.Client.Do(req)
-		if err != nil {
-			errCh <- err
-			rspCh <- rsp
-		} else {
-			rspCh <- rsp
-		}
-	}(req)
+	return Chain(clientTransport{client}, middleware...)
+}
 
-	var now time.Time
+// timeoutContext returns a context derived from parent that expires
+// after the Client timeout, if one has been set.
+func (hr *Client) timeoutContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if hr.Timeout > 0 {
+		return context.WithTimeout(parent, hr.Timeout)
+	}
+	return context.WithCancel(parent)
+}
 
-	select {
-	case err = <-errCh:
-		rsp = <-rspCh
-	case now = <-timeoutCh:
-		go hr.Transport.CancelRequest(req)
-		err = fmt.Errorf("error requesting %s: read timed out at %s after waiting %s",
-			req.URL, now.Format(time.RFC3339), hr.Timeout)
-	case rsp = <-rspCh:
-		err = nil
+// release arranges for cancel to be called once rsp is finished
+// with: immediately if there is no response, otherwise when the
+// response body is closed.
+func release(rsp *http.Response, err error, cancel context.CancelFunc) (*http.Response, error) {
+	if err != nil || rsp == nil {
+		cancel()
+		return rsp, err
 	}
+	rsp.Body = &cancelReadCloser{ReadCloser: rsp.Body, cancel: cancel}
+	return rsp, nil
+}
 
-	return
+// cancelReadCloser calls cancel when the wrapped body is closed
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (rc *cancelReadCloser) Close() error {
+	err := rc.ReadCloser.Close()
+	rc.cancel()
+	return err
 }
 
 // DoAuth performs the same work as Do, but additionally
-// attempts to handle WWW-Authenticate requests using
-// the provided session.  An error is returned if the session
-// is nil.
+// attempts to handle WWW-Authenticate and Proxy-Authenticate
+// requests using the provided session.  Proxy challenges to
+// the CONNECT request for an https tunnel are answered via the
+// Transport GetProxyConnectHeader and OnProxyConnectResponse
+// hooks installed by NewClient.  If the session is nil, the
+// Client Session is used, and if that is nil too, DoAuth performs
+// the same work as Do.  The Client timeout applies to
+// the exchange as a whole, including any requests resent to answer
+// a challenge.
+//
+// DoAuth follows redirects itself, as configured by the Client
+// RedirectPolicy, so that each new location is authorized from the
+// Session and any challenge it sends is answered.  Authorization
+// and Cookie headers set by the caller are not carried to another
+// origin.  CheckRedirect, if set, is consulted before each hop.
 func (hr *Client) DoAuth(req *http.Request, session Session) (rsp *http.Response, err error) {
+	ctx, cancel := hr.timeoutContext(req.Context())
+	rsp, err = hr.DoAuthContext(ctx, req, session)
+	return release(rsp, err, cancel)
+}
+
+// DoAuthContext performs the same work as DoAuth, but the exchange
+// is cancelled when ctx is done, rather than after the Client
+// timeout.
+func (hr *Client) DoAuthContext(ctx context.Context, req *http.Request, session Session) (rsp *http.Response, err error) {
+	if session == nil {
+		session = hr.Session
+	}
 	if session == nil {
-		return hr.Do(req)
+		return hr.DoContext(ctx, req)
 	}
 
-	auth := session.Authorization(req.URL)
-	if auth != "" {
-		req.Header.Set("Authorization", auth)
+	// send each hop without following redirects, which
+	// we follow below.
+	client := hr.Client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
 	}
 
+	auth := &AuthTransport{
+		Base:    hr.roundTripper(&client, session),
+		Session: session,
+		proxy:   hr.proxyURL,
+	}
+
+	ctx, hooks := hr.Hooks.start(ctx, req)
+	ctx, telemetry := hr.Telemetry.start(ctx, req)
+	req = req.WithContext(ctx)
+
 	// copy the request body so that we may
-	// resubmit it if we need to re-authorize
-	var body io.ReadCloser
-	if req.Body != nil {
-		var clone []io.ReadCloser
-		clone, err = session.Duplicate(req.Body, 2)
-		if err != nil {
-			return
-		}
-		req.Body, body = clone[0], clone[1]
-		for i := range clone {
-			defer clone[i].Close()
-		}
+	// resubmit it to a 307 or 308 redirect
+	body, err := newReplay(session, req)
+	if err == nil {
+		rsp, err = hr.followRedirects(req, body, auth)
+		body.Close()
 	}
 
-	rsp, err = hr.Do(req)
+	rsp, err = telemetry.complete(req, rsp, err)
+	return hooks.complete(req, rsp, err)
+}
 
-	// retry the request w/ Authorization if challenged
-	if err == nil && rsp.StatusCode == http.StatusUnauthorized {
-		var challenges Challenges
-		challenges, err = Authentication(rsp)
-		if err != nil {
-			err = fmt.Errorf("unable to parse %s WWW-Authenticate: %v", req.URL.String(), err)
-			return
+// preemptive returns the header value to send with req for the
+// challenge and header value cached from a previous request.  Digest
+// responses are bound to the request method, uri and nonce count,
+// so they must be computed anew for each request rather than
+// replayed from the cache.  Bearer tokens are requested anew so
+// that the TokenSource may rotate them.
+func preemptive(session Session, req *http.Request, cached *Challenge, auth string) (string, error) {
+	if cached != nil {
+		switch cached.Scheme {
+		case "Digest":
+			return cached.Digest(session, req)
+		case "Bearer":
+			return cached.Bearer(session, req)
 		}
+	}
+	return auth, nil
+}
+
+// replay holds a spare copy of a request body, so that the request
+// may be resent after a challenge.
+type replay struct {
+	session Session
+	spare   io.ReadCloser
+	clones  []io.ReadCloser
+}
 
-		n := len(challenges)
-		if n == 0 {
-			err = fmt.Errorf("unable to parse %s WWW-Authenticate header: %s",
-				req.URL.String(), rsp.Header.Get("Www-Authenticate"))
+// newReplay copies the body of req, if any, replacing it with one
+// copy and holding the other in reserve.
+func newReplay(session Session, req *http.Request) (r *replay, err error) {
+	r = &replay{session: session}
+	if req.Body != nil {
+		r.spare = req.Body
+		err = r.rewind(req)
+	}
+	return
+}
+
+// rewind replaces the body of req with a fresh copy of the original
+// body.  It is a no-op if the original request had no body.
+func (r *replay) rewind(req *http.Request) (err error) {
+	if r.spare == nil {
+		return nil
+	}
+
+	var clone []io.ReadCloser
+	clone, err = r.session.Duplicate(r.spare, 2)
+	if err != nil {
+		r.spare = nil
+		return
+	}
+
+	req.Body, r.spare = clone[0], clone[1]
+	r.clones = append(r.clones, clone...)
+
+	return
+}
+
+// Close closes each of the copies made of the request body.
+func (r *replay) Close() {
+	for i := range r.clones {
+		r.clones[i].Close()
+	}
+}
+
+// digestInfo verifies the Authentication-Info header, if any, sent
+// in a successful response to a request authorized by the Digest
+// challenge.  It returns a copy of the challenge to be cached for
+// subsequent requests, updated with the server's nextnonce.
+func digestInfo(session Session, req *http.Request, rsp *http.Response, challenge *Challenge) (next *Challenge, err error) {
+	info, err := AuthInfo(rsp)
+	if err != nil {
+		err = fmt.Errorf("unable to parse %s Authentication-Info: %v", req.URL.String(), err)
+		return
+	}
+
+	answered := challenge.Answered()
+
+	if info != nil {
+		err = challenge.Verify(session, req, rsp, info)
+		if err != nil {
 			return
 		}
-
-		for i, challenge := range challenges {
-			lastTry := i+1 == n
-
-			auth, err = challenge.Authorization(session, req)
-			if err != nil {
-				if err == NoCredentialsErr && !lastTry {
-					continue
-				}
-				return
-			}
-
-			if auth != "" {
-				req.Header.Set("Authorization", auth)
-
-				// if  the request body is not nil
-				// and we have additional challenges
-				// we may need to try, then we have
-				// to keep cloning the request body.
-				if body != nil {
-					if lastTry {
-						req.Body = body
-					} else {
-						var clone []io.ReadCloser
-						clone, err = session.Duplicate(body, 2)
-						if err != nil {
-							return
-						}
-
-						req.Body, body = clone[0], clone[1]
-						for i := range clone {
-							defer clone[i].Close()
-						}
-					}
-				}
-
-				rsp, err = hr.Do(req)
-				if err == nil && rsp.StatusCode != http.StatusUnauthorized {
-					session.SetAuthorization(req.URL, challenge.Domain, auth)
-					return
-				}
-			}
+		if info.NextNonce != "" {
+			answered.Nonce = info.NextNonce
 		}
 	}
 
-	return
+	return answered, nil
 }
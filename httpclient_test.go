@@ -1,12 +1,16 @@
 package httpclient
 
 import (
+	"context"
+	"crypto/md5"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -85,6 +89,340 @@ func TestDo(t *testing.T) {
 	}
 }
 
+func TestDoContext(t *testing.T) {
+	client := NewClient(0)
+
+	server := httptest.NewServer(&httpTestHandler{Mutex: new(sync.Mutex)})
+	defer server.CloseClientConnections()
+	defer server.Close()
+
+	tests := []struct {
+		Delay   time.Duration
+		Timeout time.Duration
+		Err     error
+	}{
+		{0, time.Second, nil},
+		{time.Second, 100 * time.Millisecond, context.DeadlineExceeded},
+		{time.Second, 0, context.Canceled},
+	}
+
+	for i, v := range tests {
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add(delayHeader, fmt.Sprintf("%d", v.Delay))
+
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if v.Timeout > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), v.Timeout)
+		} else {
+			ctx, cancel = context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+		}
+
+		rsp, err := client.DoContext(ctx, req)
+		if !errors.Is(err, v.Err) {
+			t.Errorf("%d: expected %v, got %v", i, v.Err, err)
+		}
+		if err == nil {
+			_, err = ioutil.ReadAll(rsp.Body)
+			if err != nil {
+				t.Errorf("%d: %v", i, err)
+			}
+			rsp.Body.Close()
+		}
+		cancel()
+	}
+}
+
+// digestTestHandler implements a minimal MD5 qop=auth Digest
+// authentication server.  A response computed against a nonce
+// other than the current one is rejected with stale=true, and
+// a replayed nonce count is rejected outright.  If info is set,
+// successful responses carry an Authentication-Info header with
+// a rspauth, which is corrupted if spoof is set, and a nextnonce
+// that replaces the current nonce.  If domain is set, challenges
+// carry it as their domain.
+type digestTestHandler struct {
+	*sync.Mutex
+	realm    string
+	username string
+	password string
+	nonce    string
+	nonces   map[string]bool
+	nc       map[string]string
+	auth     []string
+	domain   string
+	info     bool
+	spoof    bool
+}
+
+func newDigestTestHandler(nonce string) *digestTestHandler {
+	return &digestTestHandler{
+		Mutex:    new(sync.Mutex),
+		realm:    "testrealm@host.com",
+		username: "Mufasa",
+		password: "Circle Of Life",
+		nonce:    nonce,
+		nonces:   map[string]bool{nonce: true},
+		nc:       make(map[string]string),
+	}
+}
+
+// setNonce expires the current nonce in favor of nonce
+func (h *digestTestHandler) setNonce(nonce string) {
+	h.Lock()
+	h.nonce = nonce
+	h.nonces[nonce] = true
+	h.Unlock()
+}
+
+func (h *digestTestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.Lock()
+	defer h.Unlock()
+
+	auth := req.Header.Get("Authorization")
+	h.auth = append(h.auth, auth)
+
+	params := parseDigestTestParams(auth)
+
+	stale := false
+	if params != nil && h.nonces[params["nonce"]] && params["nc"] > h.nc[params["nonce"]] {
+		h.nc[params["nonce"]] = params["nc"]
+
+		kd := func(s ...string) string {
+			return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(s, ":"))))
+		}
+		ha1 := kd(h.username, h.realm, h.password)
+		ha2 := kd(req.Method, req.URL.RequestURI())
+		expect := kd(ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], ha2)
+		if params["response"] == expect && params["uri"] == req.URL.RequestURI() {
+			if params["nonce"] == h.nonce {
+				if h.info {
+					rspauth := kd(ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], kd("", req.URL.RequestURI()))
+					if h.spoof {
+						rspauth = kd(rspauth)
+					}
+					h.nonce = h.nonce + "+"
+					h.nonces[h.nonce] = true
+					w.Header().Add("Authentication-Info", fmt.Sprintf(`nextnonce="%s", qop=auth, rspauth="%s", cnonce="%s", nc=%s`,
+						h.nonce, rspauth, params["cnonce"], params["nc"]))
+				}
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("authorized"))
+				return
+			}
+			stale = true
+		}
+	}
+
+	challenge := fmt.Sprintf(`Digest realm="%s", qop="auth", nonce="%s", stale=%v`, h.realm, h.nonce, stale)
+	if h.domain != "" {
+		challenge += fmt.Sprintf(`, domain="%s"`, h.domain)
+	}
+	w.Header().Add("WWW-Authenticate", challenge)
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// parseDigestTestParams returns the name/value pairs of a Digest
+// Authorization header, or nil if auth is not a Digest header.
+func parseDigestTestParams(auth string) map[string]string {
+	if !strings.HasPrefix(auth, "Digest ") {
+		return nil
+	}
+	params := make(map[string]string)
+	for _, v := range strings.Split(auth[len("Digest "):], ", ") {
+		if i := strings.Index(v, "="); i > 0 {
+			params[v[:i]] = strings.Trim(v[i+1:], `"`)
+		}
+	}
+	return params
+}
+
+// countingCredentials counts the calls made to Login
+type countingCredentials struct {
+	Credentials
+	sync.Mutex
+	n int
+}
+
+func (c *countingCredentials) Login(uri *url.URL, realm string) (username, password string, err error) {
+	c.Lock()
+	c.n++
+	c.Unlock()
+	return c.Credentials.Login(uri, realm)
+}
+
+func TestDoAuthStale(t *testing.T) {
+	// the cached H(A1) answers a stale nonce whether or not the
+	// challenge is scoped to a domain
+	for _, domain := range []string{"", "/dir/"} {
+		testDoAuthStale(t, domain)
+	}
+}
+
+func testDoAuthStale(t *testing.T, domain string) {
+	handler := newDigestTestHandler("nonce-1")
+	handler.domain = domain
+
+	server := httptest.NewServer(handler)
+	defer server.CloseClientConnections()
+	defer server.Close()
+
+	credentials := &countingCredentials{
+		Credentials: &OrderedCredentials{[]Credential{NewCredential("", "/", handler.username, handler.password)}},
+	}
+	session := NewSession(credentials, 1000, "", -1)
+	client := NewClient(time.Duration(1 * time.Second))
+
+	for i, nonce := range []string{"nonce-1", "nonce-2", "nonce-3"} {
+		handler.setNonce(nonce)
+
+		req, err := http.NewRequest("GET", server.URL+"/dir/index.html", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := client.DoAuth(req, session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusOK {
+			t.Errorf("%q %d: expected status %d, got %d", domain, i, http.StatusOK, rsp.StatusCode)
+		}
+	}
+
+	if credentials.n != 1 {
+		t.Errorf("%q: expected 1 call to Login, got %d", domain, credentials.n)
+	}
+
+	uri, err := url.Parse(server.URL + "/dir/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge, _ := session.Authorization(uri); challenge == nil || challenge.Nonce != "nonce-3" {
+		t.Errorf("%q: expected cached challenge to use nonce-3, got %v", domain, challenge)
+	}
+}
+
+func TestDoAuthPreemptive(t *testing.T) {
+	handler := newDigestTestHandler("nonce-1")
+
+	server := httptest.NewServer(handler)
+	defer server.CloseClientConnections()
+	defer server.Close()
+
+	credentials := &OrderedCredentials{[]Credential{NewCredential("", "/", handler.username, handler.password)}}
+	session := NewSession(credentials, 1000, "", -1)
+	client := NewClient(time.Duration(1 * time.Second))
+
+	paths := []string{"/a", "/b", "/c"}
+	for i, path := range paths {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := client.DoAuth(req, session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusOK {
+			t.Errorf("%d: expected status %d, got %d", i, http.StatusOK, rsp.StatusCode)
+		}
+	}
+
+	// the first request is challenged, the rest are preemptive
+	if n := len(handler.auth); n != len(paths)+1 {
+		t.Fatalf("expected %d requests, got %d", len(paths)+1, n)
+	}
+
+	for i, path := range paths {
+		params := parseDigestTestParams(handler.auth[i+1])
+		if params["uri"] != path {
+			t.Errorf("%d: expected uri %s, got %s", i, path, params["uri"])
+		}
+		if nc := fmt.Sprintf("%08x", i+1); params["nc"] != nc {
+			t.Errorf("%d: expected nc %s, got %s", i, nc, params["nc"])
+		}
+	}
+}
+
+func TestDoAuthInfo(t *testing.T) {
+	// the rspauth is verified against the cached H(A1) whether or
+	// not the challenge is scoped to a domain
+	for _, domain := range []string{"", "/dir/"} {
+		testDoAuthInfo(t, domain)
+	}
+}
+
+func testDoAuthInfo(t *testing.T, domain string) {
+	handler := newDigestTestHandler("nonce-1")
+	handler.domain = domain
+	handler.info = true
+
+	server := httptest.NewServer(handler)
+	defer server.CloseClientConnections()
+	defer server.Close()
+
+	credentials := &OrderedCredentials{[]Credential{NewCredential("", "/", handler.username, handler.password)}}
+	session := NewSession(credentials, 1000, "", -1)
+	client := NewClient(time.Duration(1 * time.Second))
+
+	paths := []string{"/dir/a", "/dir/b", "/dir/c"}
+	for i, path := range paths {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := client.DoAuth(req, session)
+		if err != nil {
+			t.Fatalf("%q %d: %v", domain, i, err)
+		}
+		rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusOK {
+			t.Errorf("%q %d: expected status %d, got %d", domain, i, http.StatusOK, rsp.StatusCode)
+		}
+	}
+
+	// each nextnonce is used directly, without a stale round-trip
+	if n := len(handler.auth); n != len(paths)+1 {
+		t.Fatalf("%q: expected %d requests, got %d", domain, len(paths)+1, n)
+	}
+
+	nonce := "nonce-1"
+	for i := range paths {
+		params := parseDigestTestParams(handler.auth[i+1])
+		if params["nonce"] != nonce {
+			t.Errorf("%q %d: expected nonce %s, got %s", domain, i, nonce, params["nonce"])
+		}
+		nonce = nonce + "+"
+	}
+
+	handler.spoof = true
+
+	req, err := http.NewRequest("GET", server.URL+"/dir/d", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := client.DoAuth(req, session)
+	if _, ok := err.(*MutualAuthError); !ok {
+		t.Errorf("%q: expected a *MutualAuthError, got %v", domain, err)
+	}
+	if rsp != nil {
+		t.Errorf("%q: expected a nil response", domain)
+	}
+}
+
 func BenchmarkClient(b *testing.B) {
 	timeout := time.Duration(1 * time.Second)
 	client := NewClient(timeout)
@@ -0,0 +1,762 @@
+package httpclient
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureKey is a key used to create or verify RFC 9421 HTTP
+// Message Signatures.  Algorithm names one of the registered
+// signature algorithms, and determines the type of Key:
+//
+//	hmac-sha256        []byte
+//	ed25519            ed25519.PrivateKey or ed25519.PublicKey
+//	ecdsa-p256-sha256  *ecdsa.PrivateKey or *ecdsa.PublicKey
+//	rsa-pss-sha512     *rsa.PrivateKey or *rsa.PublicKey
+type SignatureKey struct {
+	ID        string
+	Algorithm string
+	Key       interface{}
+}
+
+// Keystore supplies the keys used to sign requests and verify
+// responses, looked up per host in the same manner as Credentials.
+type Keystore interface {
+	// Key returns the key identified by keyID for the specified
+	// uri.  An empty keyID requests the key to sign with.  If no
+	// key could be found, NoCredentialsErr should be returned.
+	Key(uri *url.URL, keyID string) (key *SignatureKey, err error)
+}
+
+// HostKey pairs a SignatureKey with the domain and path it applies
+// to, matched as for a Credential.
+type HostKey struct {
+	Domain string
+	Path   string
+	SignatureKey
+}
+
+// HostKeys implements Keystore, returning the first key whose
+// domain and path match the uri.
+type HostKeys []HostKey
+
+func (keys HostKeys) Key(uri *url.URL, keyID string) (key *SignatureKey, err error) {
+	for i := range keys {
+		c := NewCredential(keys[i].Domain, keys[i].Path, "", "")
+		if c.Matches(uri) && (keyID == "" || keyID == keys[i].ID) {
+			return &keys[i].SignatureKey, nil
+		}
+	}
+	return nil, NoCredentialsErr
+}
+
+// SignatureError is returned when a response signature is missing
+// or does not verify.
+type SignatureError struct {
+	URL    string
+	Reason string
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("signature of %s failed to verify: %s", e.URL, e.Reason)
+}
+
+// HTTPSigner implements Signer, adding RFC 9421 Signature-Input and
+// Signature headers to requests.  If the covered components include
+// content-digest, a RFC 9530 Content-Digest header is computed from
+// the request body, buffered via Session.NewProxyReadCloser.
+type HTTPSigner struct {
+	keys       Keystore
+	session    Session
+	label      string
+	components []string
+
+	// now returns the current time, and may be replaced in tests
+	now func() time.Time
+}
+
+// NewHTTPSigner returns an HTTPSigner covering the specified
+// components, e.g., "@method", "@target-uri", "content-digest", or
+// lowercased header field names.  If no components are specified,
+// "@method", "@target-uri" and "content-digest" are covered.
+// Requests without a body omit content-digest.
+func NewHTTPSigner(keys Keystore, session Session, components ...string) *HTTPSigner {
+	if len(components) == 0 {
+		components = []string{"@method", "@target-uri", "content-digest"}
+	}
+	return &HTTPSigner{
+		keys:       keys,
+		session:    session,
+		label:      "sig1",
+		components: components,
+		now:        time.Now,
+	}
+}
+
+func (s *HTTPSigner) Sign(req *http.Request) (err error) {
+	key, err := s.keys.Key(req.URL, "")
+	if err != nil {
+		return
+	}
+
+	var components []string
+	for _, name := range s.components {
+		if name == "content-digest" {
+			if req.Body == nil {
+				continue
+			}
+			var digest string
+			digest, req.Body, err = contentDigest(s.session, req.Body)
+			if err != nil {
+				return
+			}
+			req.Header.Set("Content-Digest", digest)
+		}
+		components = append(components, name)
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("(")
+	for i, name := range components {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
+		buf.WriteString(strconv.Quote(name))
+	}
+	buf.WriteString(")")
+	fmt.Fprintf(buf, ";created=%d;keyid=%s;alg=%s",
+		s.now().Unix(), strconv.Quote(key.ID), strconv.Quote(key.Algorithm))
+	params := buf.String()
+
+	var covered []sfComponent
+	for _, name := range components {
+		covered = append(covered, sfComponent{name: name})
+	}
+
+	base, err := signatureBase(covered, params, req, nil)
+	if err != nil {
+		return
+	}
+
+	sig, err := signatureSign(key, []byte(base))
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Signature-Input", s.label+"="+params)
+	req.Header.Set("Signature", s.label+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+
+	return nil
+}
+
+// HTTPVerifier verifies RFC 9421 signatures on responses.
+type HTTPVerifier struct {
+	keys    Keystore
+	session Session
+
+	// now returns the current time, and may be replaced in tests
+	now func() time.Time
+}
+
+// NewHTTPVerifier returns an HTTPVerifier looking up keys in keys,
+// and buffering response bodies via Session.NewProxyReadCloser when
+// checking a covered Content-Digest.
+func NewHTTPVerifier(keys Keystore, session Session) *HTTPVerifier {
+	return &HTTPVerifier{
+		keys:    keys,
+		session: session,
+		now:     time.Now,
+	}
+}
+
+// Verify checks each signature on rsp, returning a *SignatureError
+// if the response is unsigned or any signature fails to verify.  If
+// a signature covers content-digest, the digest is checked against
+// the response body, which remains readable.
+func (v *HTTPVerifier) Verify(rsp *http.Response) (err error) {
+	var uri string
+	var reqURL *url.URL
+	if rsp.Request != nil {
+		reqURL = rsp.Request.URL
+		uri = reqURL.String()
+	}
+
+	fail := func(reason string) error {
+		return &SignatureError{URL: uri, Reason: reason}
+	}
+
+	inputs, err := parseSFDictionary(rsp.Header.Get("Signature-Input"))
+	if err != nil {
+		return fail(fmt.Sprintf("unable to parse Signature-Input: %v", err))
+	}
+	signatures, err := parseSFDictionary(rsp.Header.Get("Signature"))
+	if err != nil {
+		return fail(fmt.Sprintf("unable to parse Signature: %v", err))
+	}
+	if len(inputs) == 0 {
+		return fail("response is not signed")
+	}
+
+	for _, input := range inputs {
+		var signature *sfMember
+		for i := range signatures {
+			if signatures[i].key == input.key {
+				signature = &signatures[i]
+			}
+		}
+		if signature == nil || signature.bytes == nil {
+			return fail(fmt.Sprintf("no Signature for %s", input.key))
+		}
+
+		keyID, _ := strconv.Unquote(input.param("keyid"))
+		if reqURL == nil {
+			return fail("response has no request")
+		}
+		key, err := v.keys.Key(reqURL, keyID)
+		if err != nil {
+			return fail(fmt.Sprintf("no key %q: %v", keyID, err))
+		}
+
+		if alg, _ := strconv.Unquote(input.param("alg")); alg != "" && alg != key.Algorithm {
+			return fail(fmt.Sprintf("algorithm %s does not match key %s", alg, key.Algorithm))
+		}
+
+		if s := input.param("expires"); s != "" {
+			expires, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || v.now().Unix() > expires {
+				return fail("signature has expired")
+			}
+		}
+
+		for _, c := range input.list {
+			if c.name == "content-digest" && !c.req {
+				var digest string
+				if rsp.Body != nil {
+					digest, rsp.Body, err = contentDigest(v.session, rsp.Body)
+					if err != nil {
+						return err
+					}
+				}
+				if !contentDigestMatches(rsp.Header.Get("Content-Digest"), digest) {
+					return fail("Content-Digest does not match body")
+				}
+			}
+		}
+
+		base, err := signatureBase(input.list, input.raw, rsp.Request, rsp)
+		if err != nil {
+			return fail(err.Error())
+		}
+
+		err = signatureVerify(key, []byte(base), signature.bytes)
+		if err != nil {
+			return fail(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// contentDigest returns the RFC 9530 sha-256 Content-Digest of body,
+// and a new io.ReadCloser holding a copy of the body.
+func contentDigest(session Session, body io.ReadCloser) (digest string, rc io.ReadCloser, err error) {
+	defer body.Close()
+
+	h := sha256.New()
+	prc := session.NewProxyReadCloser()
+	mw := io.MultiWriter(h, prc)
+
+	_, err = io.Copy(mw, body)
+	if err != nil {
+		return
+	}
+
+	err = prc.Close()
+	if err != nil {
+		return
+	}
+
+	rc, err = prc.ReadCloser()
+	if err != nil {
+		return
+	}
+
+	digest = "sha-256=:" + base64.StdEncoding.EncodeToString(h.Sum(nil)) + ":"
+
+	return digest, rc, nil
+}
+
+// contentDigestMatches reports whether the sha-256 member of header
+// matches digest.
+func contentDigestMatches(header, digest string) bool {
+	members, err := parseSFDictionary(header)
+	if err != nil {
+		return false
+	}
+	for _, m := range members {
+		if m.key == "sha-256" && m.bytes != nil {
+			return hmac.Equal([]byte("sha-256=:"+base64.StdEncoding.EncodeToString(m.bytes)+":"), []byte(digest))
+		}
+	}
+	return false
+}
+
+// signatureBase returns the RFC 9421 2.5 signature base for the
+// covered components of req, or of rsp if it is not nil, where
+// params is the serialized @signature-params value.
+func signatureBase(covered []sfComponent, params string, req *http.Request, rsp *http.Response) (base string, err error) {
+	buf := &bytes.Buffer{}
+
+	for _, c := range covered {
+		var value string
+
+		msgReq, msgHeader := req, http.Header(nil)
+		if rsp != nil && !c.req {
+			msgReq = nil
+			msgHeader = rsp.Header
+		} else if req != nil {
+			msgHeader = req.Header
+		}
+
+		if strings.HasPrefix(c.name, "@") {
+			if c.name == "@status" {
+				if rsp == nil || c.req {
+					return "", fmt.Errorf("@status is only defined for responses")
+				}
+				value = strconv.Itoa(rsp.StatusCode)
+			} else {
+				if msgReq == nil {
+					return "", fmt.Errorf("%s requires the req parameter in a response", c.name)
+				}
+				value, err = derivedComponent(c.name, msgReq)
+				if err != nil {
+					return
+				}
+			}
+		} else {
+			values, ok := msgHeader[http.CanonicalHeaderKey(c.name)]
+			if !ok {
+				return "", fmt.Errorf("covered header %s is missing", c.name)
+			}
+			trimmed := make([]string, len(values))
+			for i := range values {
+				trimmed[i] = strings.TrimSpace(values[i])
+			}
+			value = strings.Join(trimmed, ", ")
+		}
+
+		buf.WriteString(strconv.Quote(c.name))
+		if c.req {
+			buf.WriteString(";req")
+		}
+		buf.WriteString(": ")
+		buf.WriteString(value)
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString(`"@signature-params": `)
+	buf.WriteString(params)
+
+	return buf.String(), nil
+}
+
+// derivedComponent returns the value of the RFC 9421 2.2 derived
+// component name for req.
+func derivedComponent(name string, req *http.Request) (value string, err error) {
+	scheme := req.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	switch name {
+	case "@method":
+		return req.Method, nil
+	case "@target-uri":
+		uri := *req.URL
+		uri.Scheme, uri.Host = scheme, host
+		return uri.String(), nil
+	case "@authority":
+		return strings.ToLower(host), nil
+	case "@scheme":
+		return strings.ToLower(scheme), nil
+	case "@request-target":
+		return req.URL.RequestURI(), nil
+	case "@path":
+		path := req.URL.EscapedPath()
+		if path == "" {
+			path = "/"
+		}
+		return path, nil
+	case "@query":
+		return "?" + req.URL.RawQuery, nil
+	}
+	return "", fmt.Errorf("unsupported derived component %s", name)
+}
+
+func signatureSign(key *SignatureKey, base []byte) (sig []byte, err error) {
+	switch key.Algorithm {
+	case "hmac-sha256":
+		secret, ok := key.Key.([]byte)
+		if !ok {
+			break
+		}
+		h := hmac.New(sha256.New, secret)
+		h.Write(base)
+		return h.Sum(nil), nil
+	case "ed25519":
+		priv, ok := key.Key.(ed25519.PrivateKey)
+		if !ok {
+			break
+		}
+		return ed25519.Sign(priv, base), nil
+	case "ecdsa-p256-sha256":
+		priv, ok := key.Key.(*ecdsa.PrivateKey)
+		if !ok {
+			break
+		}
+		digest := sha256.Sum256(base)
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, priv, digest[:])
+		if err != nil {
+			return
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	case "rsa-pss-sha512":
+		priv, ok := key.Key.(*rsa.PrivateKey)
+		if !ok {
+			break
+		}
+		digest := sha512.Sum512(base)
+		return rsa.SignPSS(rand.Reader, priv, crypto.SHA512, digest[:], &rsa.PSSOptions{SaltLength: 64})
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm: %s", key.Algorithm)
+	}
+	return nil, fmt.Errorf("key %s is not a %s signing key", key.ID, key.Algorithm)
+}
+
+func signatureVerify(key *SignatureKey, base []byte, sig []byte) (err error) {
+	invalid := errors.New("signature does not match")
+
+	switch key.Algorithm {
+	case "hmac-sha256":
+		secret, ok := key.Key.([]byte)
+		if !ok {
+			break
+		}
+		h := hmac.New(sha256.New, secret)
+		h.Write(base)
+		if !hmac.Equal(h.Sum(nil), sig) {
+			return invalid
+		}
+		return nil
+	case "ed25519":
+		var pub ed25519.PublicKey
+		switch k := key.Key.(type) {
+		case ed25519.PublicKey:
+			pub = k
+		case ed25519.PrivateKey:
+			pub = k.Public().(ed25519.PublicKey)
+		default:
+			return fmt.Errorf("key %s is not a %s key", key.ID, key.Algorithm)
+		}
+		if !ed25519.Verify(pub, base, sig) {
+			return invalid
+		}
+		return nil
+	case "ecdsa-p256-sha256":
+		var pub *ecdsa.PublicKey
+		switch k := key.Key.(type) {
+		case *ecdsa.PublicKey:
+			pub = k
+		case *ecdsa.PrivateKey:
+			pub = &k.PublicKey
+		default:
+			return fmt.Errorf("key %s is not a %s key", key.ID, key.Algorithm)
+		}
+		if len(sig) != 64 || pub.Curve != elliptic.P256() {
+			return invalid
+		}
+		digest := sha256.Sum256(base)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return invalid
+		}
+		return nil
+	case "rsa-pss-sha512":
+		var pub *rsa.PublicKey
+		switch k := key.Key.(type) {
+		case *rsa.PublicKey:
+			pub = k
+		case *rsa.PrivateKey:
+			pub = &k.PublicKey
+		default:
+			return fmt.Errorf("key %s is not a %s key", key.ID, key.Algorithm)
+		}
+		digest := sha512.Sum512(base)
+		if rsa.VerifyPSS(pub, crypto.SHA512, digest[:], sig, &rsa.PSSOptions{SaltLength: 64}) != nil {
+			return invalid
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signature algorithm: %s", key.Algorithm)
+	}
+	return fmt.Errorf("key %s is not a %s key", key.ID, key.Algorithm)
+}
+
+// sfComponent is a covered component identifier from an RFC 9421
+// Signature-Input inner list.  Of the component parameters, only req
+// is supported.
+type sfComponent struct {
+	name string
+	req  bool
+}
+
+// sfMember is a member of an RFC 8941 structured field dictionary,
+// holding either an inner list of component identifiers, as sent in
+// Signature-Input, or a byte sequence, as sent in Signature or
+// Content-Digest.  raw holds the serialized member value, which for
+// Signature-Input is the @signature-params value.
+type sfMember struct {
+	key    string
+	list   []sfComponent
+	bytes  []byte
+	params [][2]string
+	raw    string
+}
+
+// param returns the serialized value of the named parameter
+func (m *sfMember) param(name string) string {
+	for _, p := range m.params {
+		if p[0] == name {
+			return p[1]
+		}
+	}
+	return ""
+}
+
+// parseSFDictionary parses the subset of RFC 8941 dictionaries used
+// by Signature-Input, Signature and Content-Digest.
+func parseSFDictionary(s string) (members []sfMember, err error) {
+	p := &sfParser{s: s}
+
+	p.skip(" \t")
+	for !p.done() {
+		var m sfMember
+
+		m.key = p.key()
+		if m.key == "" {
+			return nil, fmt.Errorf("expected a key at position %d", p.i)
+		}
+		if !p.accept('=') {
+			return nil, fmt.Errorf("expected '=' after %s", m.key)
+		}
+
+		start := p.i
+		switch p.peek() {
+		case '(':
+			m.list, err = p.innerList()
+		case ':':
+			m.bytes, err = p.byteSequence()
+		default:
+			_, err = p.bareItem()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		m.params, err = p.params()
+		if err != nil {
+			return nil, err
+		}
+		m.raw = p.s[start:p.i]
+
+		members = append(members, m)
+
+		p.skip(" \t")
+		if p.done() {
+			break
+		}
+		if !p.accept(',') {
+			return nil, fmt.Errorf("expected ',' at position %d", p.i)
+		}
+		p.skip(" \t")
+	}
+
+	return members, nil
+}
+
+type sfParser struct {
+	s string
+	i int
+}
+
+func (p *sfParser) done() bool { return p.i >= len(p.s) }
+
+func (p *sfParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sfParser) accept(c byte) bool {
+	if p.peek() == c && !p.done() {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *sfParser) skip(set string) {
+	for !p.done() && strings.IndexByte(set, p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *sfParser) key() string {
+	start := p.i
+	for !p.done() {
+		c := p.s[p.i]
+		if ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '_' || c == '-' || c == '.' || c == '*' {
+			p.i++
+		} else {
+			break
+		}
+	}
+	return p.s[start:p.i]
+}
+
+func (p *sfParser) innerList() (list []sfComponent, err error) {
+	p.accept('(')
+	for {
+		p.skip(" ")
+		if p.accept(')') {
+			return list, nil
+		}
+		if p.peek() != '"' {
+			return nil, fmt.Errorf("expected a component string at position %d", p.i)
+		}
+		var c sfComponent
+		c.name, err = p.str()
+		if err != nil {
+			return
+		}
+		var params [][2]string
+		params, err = p.params()
+		if err != nil {
+			return
+		}
+		for _, v := range params {
+			if v[0] != "req" {
+				return nil, fmt.Errorf("unsupported component parameter %s", v[0])
+			}
+			c.req = true
+		}
+		list = append(list, c)
+	}
+}
+
+func (p *sfParser) params() (params [][2]string, err error) {
+	for p.accept(';') {
+		p.skip(" ")
+		k := p.key()
+		if k == "" {
+			return nil, fmt.Errorf("expected a parameter key at position %d", p.i)
+		}
+		v := "?1"
+		if p.accept('=') {
+			v, err = p.bareItem()
+			if err != nil {
+				return
+			}
+		}
+		params = append(params, [2]string{k, v})
+	}
+	return
+}
+
+// bareItem returns the serialized form of the item at the parser
+// position.
+func (p *sfParser) bareItem() (item string, err error) {
+	start := p.i
+	switch c := p.peek(); {
+	case c == '"':
+		_, err = p.str()
+	case c == ':':
+		_, err = p.byteSequence()
+	case c == '?':
+		p.i++
+		if !p.accept('0') && !p.accept('1') {
+			err = fmt.Errorf("expected a boolean at position %d", p.i)
+		}
+	case c == '-' || ('0' <= c && c <= '9'):
+		p.i++
+		p.skip("0123456789.")
+	case ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '*':
+		p.skip("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#$%&'*+-.^_`|~:/")
+	default:
+		err = fmt.Errorf("unexpected %q at position %d", c, p.i)
+	}
+	return p.s[start:p.i], err
+}
+
+func (p *sfParser) str() (s string, err error) {
+	start := p.i
+	p.accept('"')
+	for !p.done() {
+		switch p.s[p.i] {
+		case '\\':
+			p.i += 2
+		case '"':
+			p.i++
+			return strconv.Unquote(p.s[start:p.i])
+		default:
+			p.i++
+		}
+	}
+	return "", fmt.Errorf("unterminated string at position %d", start)
+}
+
+func (p *sfParser) byteSequence() (b []byte, err error) {
+	start := p.i
+	p.accept(':')
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated byte sequence at position %d", start)
+	}
+	b, err = base64.StdEncoding.DecodeString(p.s[p.i : p.i+end])
+	p.i += end + 1
+	if b == nil && err == nil {
+		b = []byte{}
+	}
+	return
+}
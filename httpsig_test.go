@@ -0,0 +1,256 @@
+package httpclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHTTPSigRFCVector checks the RFC 9421 B.2.5 HMAC-SHA256 example
+func TestHTTPSigRFCVector(t *testing.T) {
+	secret, err := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &SignatureKey{ID: "test-shared-secret", Algorithm: "hmac-sha256", Key: secret}
+
+	req, err := http.NewRequest("POST", "https://example.com/foo?param=Value&Pet=dog", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	req.Header.Set("Content-Type", "application/json")
+
+	input, err := parseSFDictionary(`sig-b25=("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, err := signatureBase(input[0].list, input[0].raw, req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@authority": example.com
+"content-type": application/json
+"@signature-params": ("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`
+	if base != expected {
+		t.Errorf("expected signature base\n%s\ngot\n%s", expected, base)
+	}
+
+	sig, err := signatureSign(key, []byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := base64.StdEncoding.EncodeToString(sig); s != "pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=" {
+		t.Errorf("expected signature pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=, got %s", s)
+	}
+}
+
+// TestContentDigest checks the RFC 9530 2 example
+func TestContentDigest(t *testing.T) {
+	session := NewSession(&OrderedCredentials{}, 1000, "", -1)
+
+	digest, rc, err := contentDigest(session, ioutil.NopCloser(strings.NewReader(`{"hello": "world"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:" {
+		t.Errorf("unexpected digest %s", digest)
+	}
+
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"hello": "world"}` {
+		t.Errorf("unexpected body %q", string(b))
+	}
+
+	if !contentDigestMatches("sha-512=:AAAA:, sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", digest) {
+		t.Errorf("expected digest to match")
+	}
+}
+
+// httpSigTestHandler verifies the ed25519 signature on each request,
+// and signs its response with an hmac-sha256 key.
+type httpSigTestHandler struct {
+	t      *testing.T
+	client ed25519.PublicKey
+	server *SignatureKey
+	body   string
+	tamper bool
+}
+
+func (h *httpSigTestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	t := h.t
+
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(b) != h.body {
+		t.Errorf("expected body %q, got %q", h.body, string(b))
+	}
+
+	sum := sha256.Sum256(b)
+	if s := req.Header.Get("Content-Digest"); s != "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":" {
+		t.Errorf("unexpected Content-Digest %s", s)
+	}
+
+	inputs, err := parseSFDictionary(req.Header.Get("Signature-Input"))
+	if err != nil || len(inputs) != 1 {
+		t.Errorf("unable to parse Signature-Input %s: %v", req.Header.Get("Signature-Input"), err)
+		return
+	}
+	signatures, err := parseSFDictionary(req.Header.Get("Signature"))
+	if err != nil || len(signatures) != 1 {
+		t.Errorf("unable to parse Signature %s: %v", req.Header.Get("Signature"), err)
+		return
+	}
+
+	req.URL.Host = req.Host
+	base, err := signatureBase(inputs[0].list, inputs[0].raw, req, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.HasPrefix(base, `"@method": POST`+"\n"+`"@target-uri": http://`+req.Host+"/upload?id=1\n") {
+		t.Errorf("unexpected signature base %s", base)
+	}
+	if !ed25519.Verify(h.client, []byte(base), signatures[0].bytes) {
+		t.Errorf("request signature failed to verify")
+	}
+
+	body := "accepted"
+	sum = sha256.Sum256([]byte(body))
+
+	rsp := &http.Response{StatusCode: http.StatusOK, Header: w.Header()}
+	rsp.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+
+	params := `("@status" "content-digest" "@method";req);created=1618884473;keyid="server";alg="hmac-sha256"`
+	inputs, _ = parseSFDictionary("rsp=" + params)
+	base, err = signatureBase(inputs[0].list, params, req, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sig, err := signatureSign(h.server, []byte(base))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	w.Header().Set("Signature-Input", "rsp="+params)
+	w.Header().Set("Signature", "rsp=:"+base64.StdEncoding.EncodeToString(sig)+":")
+
+	if h.tamper {
+		body = "accepteD"
+	}
+	w.Write([]byte(body))
+}
+
+func TestHTTPSig(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKey := SignatureKey{ID: "server", Algorithm: "hmac-sha256", Key: []byte("shared secret")}
+
+	handler := &httpSigTestHandler{
+		t:      t,
+		client: pub,
+		server: &serverKey,
+		body:   "a body long enough to spill",
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	keys := HostKeys{
+		{Path: "/upload", SignatureKey: SignatureKey{ID: "client", Algorithm: "ed25519", Key: priv}},
+		{SignatureKey: serverKey},
+	}
+
+	// a limit of 8 bytes spills bodies to a temporary file
+	session := NewSession(&OrderedCredentials{}, 1000, "", 8)
+
+	client := NewClient(time.Duration(1 * time.Second))
+	client.Signer = NewHTTPSigner(keys, session)
+
+	verifier := NewHTTPVerifier(keys, session)
+
+	for i, tamper := range []bool{false, true} {
+		handler.tamper = tamper
+
+		req, err := http.NewRequest("POST", server.URL+"/upload?id=1", strings.NewReader(handler.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = verifier.Verify(rsp)
+		if tamper {
+			if _, ok := err.(*SignatureError); !ok {
+				t.Errorf("%d: expected a SignatureError, got %v", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("%d: %v", i, err)
+		}
+
+		b, err := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tamper && string(b) != "accepted" {
+			t.Errorf("%d: expected body accepted, got %q", i, string(b))
+		}
+	}
+}
+
+func TestSignatureAlgorithms(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(nil)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	keys := []*SignatureKey{
+		{ID: "hmac", Algorithm: "hmac-sha256", Key: []byte("secret")},
+		{ID: "ed25519", Algorithm: "ed25519", Key: edKey},
+		{ID: "ecdsa", Algorithm: "ecdsa-p256-sha256", Key: ecKey},
+		{ID: "rsa", Algorithm: "rsa-pss-sha512", Key: rsaKey},
+	}
+
+	base := []byte(`"@method": GET`)
+
+	for i, key := range keys {
+		sig, err := signatureSign(key, base)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		err = signatureVerify(key, base, sig)
+		if err != nil {
+			t.Errorf("%d: expected %s signature to verify, got %v", i, key.Algorithm, err)
+		}
+		err = signatureVerify(key, []byte(`"@method": PUT`), sig)
+		if err == nil {
+			t.Errorf("%d: expected %s signature over a different base to fail", i, key.Algorithm)
+		}
+	}
+}
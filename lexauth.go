@@ -10,6 +10,7 @@ const (
 	ItemIgnore lexrec.ItemType = lexrec.ItemEOF + 1 + iota
 	ItemBasic
 	ItemDigest
+	ItemBearer
 	ItemRealm
 	ItemDomain
 	ItemNonce
@@ -17,6 +18,15 @@ const (
 	ItemStale
 	ItemAlgorithm
 	ItemQop
+	ItemCharset
+	ItemUserhash
+	ItemNextnonce
+	ItemRspauth
+	ItemCnonce
+	ItemNc
+	ItemScope
+	ItemBearerError
+	ItemErrorDescription
 	ItemAuthParam
 )
 
@@ -32,6 +42,8 @@ func itemName(t lexrec.ItemType) string {
 		return "Basic"
 	case ItemDigest:
 		return "Digest"
+	case ItemBearer:
+		return "Bearer"
 	case ItemIgnore:
 		return "ignore"
 	case ItemRealm:
@@ -48,6 +60,24 @@ func itemName(t lexrec.ItemType) string {
 		return "algorithm"
 	case ItemQop:
 		return "qop"
+	case ItemCharset:
+		return "charset"
+	case ItemUserhash:
+		return "userhash"
+	case ItemNextnonce:
+		return "nextnonce"
+	case ItemRspauth:
+		return "rspauth"
+	case ItemCnonce:
+		return "cnonce"
+	case ItemNc:
+		return "nc"
+	case ItemScope:
+		return "scope"
+	case ItemBearerError:
+		return "error"
+	case ItemErrorDescription:
+		return "error_description"
 	case ItemAuthParam:
 		return "auth-param"
 	default:
@@ -100,7 +130,7 @@ var ctl = "\x00" +
 var nontoken = separators + whitespace + ctl
 
 // emitWWWAuthenticate drives a lexer to parse an RFC 2617
-// Basic or Digest authentication challenge
+// Basic or Digest, or an RFC 6750 Bearer, authentication challenge
 //
 // The specification defines a Basic authentication challenge as:
 //
@@ -114,7 +144,8 @@ var nontoken = separators + whitespace + ctl
 // challenge         =  "Digest" digest-challenge
 //
 // digest-challenge  = 1#( realm | [ domain ] | nonce | [ opaque ] |[ stale ]
-//                          | [ algorithm ] | [ qop-options ] | [auth-param] )
+//                          | [ algorithm ] | [ qop-options ] | [ charset ]
+//                          | [ userhash ] | [auth-param] )
 //
 // The BNF for these constructs:
 //
@@ -127,6 +158,8 @@ var nontoken = separators + whitespace + ctl
 //  algorithm         = "algorithm" "=" ( "MD5" | "MD5-sess" | token )
 //  qop-options       = "qop" "=" <"> 1#qop-value <">
 //  qop-value         = "auth" | "auth-int" | token
+//  charset           = "charset" "=" "UTF-8"
+//  userhash          = "userhash" "=" ( "true" | "false" )
 //  auth-param        = token "=" ( token | quoted-string )
 //
 //  token             = 1*<any CHAR except CTLs or separators>
@@ -192,6 +225,23 @@ func emitWWWAuthenticate(l *lexrec.Lexer) {
 
 			emitDigestParams(l)
 
+		case "bearer":
+			l.Emit(ItemBearer)
+
+			// a Bearer challenge may omit all parameters
+			if l.Peek() == lexrec.EOF {
+				return
+			}
+
+			if l.AcceptRun(whitespace) {
+				l.Skip()
+			} else {
+				l.Errorf("expected whitespace after 'Bearer', got %q", l.Peek())
+				return
+			}
+
+			emitBearerParams(l)
+
 		default:
 			advanceChallenge(l)
 		}
@@ -291,6 +341,10 @@ func emitDigestParams(l *lexrec.Lexer) {
 			emitToken(l, ItemAlgorithm)
 		case "qop":
 			emitQuotedToken(l, ItemQop)
+		case "charset":
+			emitValue(l, ItemCharset)
+		case "userhash":
+			emitValue(l, ItemUserhash)
 		default:
 			r := l.Peek()
 			if r == ',' || isSpace(r) || r == lexrec.EOF {
@@ -303,6 +357,110 @@ func emitDigestParams(l *lexrec.Lexer) {
 	}
 }
 
+// emitBearerParams expects to be positioned at the start of a
+// Bearer authentication parameter, per RFC 6750 3:
+//
+//  challenge         = "Bearer" [ 1*SP 1#param ]
+//  param             = realm / scope / error / error_description
+//                      / error_uri / auth-param
+//  scope             = "scope" "=" <"> scope-token *( SP scope-token ) <">
+//  error             = "error" "=" quoted-string
+//  error_description = "error_description" "=" quoted-string
+//
+// Values are accepted as either a token or a quoted-string.
+func emitBearerParams(l *lexrec.Lexer) {
+
+	expectParam := true
+
+	for expectParam {
+		if !l.ExceptRun(nontoken) {
+			l.Errorf("emitBearerParams: expected a token character, got %q", l.Peek())
+			return
+		}
+
+		switch strings.ToLower(string(l.Bytes())) {
+		case "realm":
+			emitQuotedToken(l, ItemRealm)
+		case "scope":
+			emitValue(l, ItemScope)
+		case "error":
+			emitValue(l, ItemBearerError)
+		case "error_description":
+			emitValue(l, ItemErrorDescription)
+		default:
+			r := l.Peek()
+			if r == ',' || isSpace(r) || r == lexrec.EOF {
+				return
+			}
+			ignoreToken(l)
+		}
+
+		expectParam = advanceParam(l)
+	}
+}
+
+// emitAuthenticationInfo drives a lexer to parse an RFC 7615
+// Authentication-Info header, as sent by a server following a
+// successful Digest authentication.
+//
+// The specification defines the header as:
+//
+//  Authentication-Info = #auth-param
+//
+// where the auth-params recognized for Digest (RFC 7616 3.5) are:
+//
+//  nextnonce         = "nextnonce" "=" quoted-string
+//  qop               = "qop" "=" token
+//  rspauth           = "rspauth" "=" quoted-string
+//  cnonce            = "cnonce" "=" quoted-string
+//  nc                = "nc" "=" 8LHEX
+//
+// Values are accepted as either a token or a quoted-string.
+//
+// An example header:
+//
+//   Authentication-Info: qop=auth,
+//			rspauth="6629fae49393a05397450978507c4ef1",
+//			cnonce="0a4f113b", nc=00000001
+//
+func emitAuthenticationInfo(l *lexrec.Lexer) {
+	defer l.Emit(lexrec.ItemEOF)
+
+	if l.AcceptRun(whitespace) {
+		l.Skip()
+	}
+
+	expectParam := true
+
+	for expectParam {
+		if l.Peek() == lexrec.EOF {
+			return
+		}
+
+		if !l.ExceptRun(nontoken) {
+			l.Errorf("emitAuthenticationInfo: expected a token character, got %q", l.Peek())
+			return
+		}
+
+		switch strings.ToLower(string(l.Bytes())) {
+		case "nextnonce":
+			emitValue(l, ItemNextnonce)
+		case "qop":
+			emitValue(l, ItemQop)
+		case "rspauth":
+			emitValue(l, ItemRspauth)
+		case "cnonce":
+			emitValue(l, ItemCnonce)
+		case "nc":
+			emitValue(l, ItemNc)
+		default:
+			ignoreToken(l)
+		}
+
+		expectParam = advanceParam(l)
+	}
+}
+
 // emitQuotedToken transmits the quoted-string value from <name>=<value>
 func emitQuotedToken(l *lexrec.Lexer, t lexrec.ItemType) {
 	if !l.Accept("=") {
@@ -334,6 +492,31 @@ func emitToken(l *lexrec.Lexer, t lexrec.ItemType) {
 	l.Emit(t)
 }
 
+// emitValue emits the value from <name>=<value>, where the value
+// may be either a token or a quoted-string.
+func emitValue(l *lexrec.Lexer, t lexrec.ItemType) {
+	if !l.Accept("=") {
+		l.Errorf("emitValue: expected '=' after '%s', got %q'", itemName(t), l.Peek())
+		return
+	}
+
+	l.Skip()
+
+	if l.Peek() == '"' {
+		if !lexrec.Quote(l, t, true) {
+			l.Errorf("emitValue: expected a quoted string after '%s=', got %q", itemName(t), l.Peek())
+		}
+		return
+	}
+
+	if !l.ExceptRun(nontoken) {
+		l.Errorf("emitValue: expected a token character, got %q", l.Peek())
+		return
+	}
+
+	l.Emit(t)
+}
+
 // emitBoolToken emits the token value from <name>=<value>, where the
 // value is either "true" or "false" (case insensitive)
 func emitBoolToken(l *lexrec.Lexer, t lexrec.ItemType) {
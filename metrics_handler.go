@@ -0,0 +1,252 @@
+package httpclient
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MetricsHandler counts the requests sent by a Client, and the work
+// done by its Sessions.  It holds no global state: each Client to be
+// told apart should have a MetricsHandler of its own, whose samples
+// are labelled with its name.
+//
+// Register a Client and its Sessions with a MetricsHandler
+// explicitly.  The MetricsHandler is itself an http.Handler, writing
+// its counters in the Prometheus text exposition format, and several
+// may be written together via WriteMetrics.  To add the counters to a
+// Prometheus registry instead, register the prometheus.Collector that
+// promcollector.New returns for the MetricsHandler.  The counters
+// are:
+//
+//	httpclient_requests_total{host,method,status}
+//	httpclient_timeouts_total{phase}
+//	httpclient_auth_challenges_total{scheme}
+//	httpclient_auth_cache_hits_total
+//	httpclient_auth_cache_misses_total
+//	httpclient_nonce_evictions_total
+//	httpclient_body_spills_total
+//	httpclient_body_spilled_bytes_total
+type MetricsHandler struct {
+	name string
+
+	mu         sync.Mutex
+	requests   map[[3]string]uint64
+	timeouts   map[string]uint64
+	challenges map[string]uint64
+	sessions   []statsSession
+}
+
+// statsSession is a Session that counts its work, as those returned
+// by NewSession do.
+type statsSession interface {
+	Stats() SessionStats
+}
+
+// NewMetricsHandler returns a MetricsHandler labelling its samples with
+// client="name".
+func NewMetricsHandler(name string) *MetricsHandler {
+	return &MetricsHandler{
+		name:       name,
+		requests:   make(map[[3]string]uint64),
+		timeouts:   make(map[string]uint64),
+		challenges: make(map[string]uint64),
+	}
+}
+
+// Register arranges for h to count the requests sent by hr, by
+// adding h to the Meter of hr Telemetry.  Any Tracer or Meter already
+// set is kept, and told of each request as before.
+func (h *MetricsHandler) Register(hr *Client) {
+	var telemetry Telemetry
+	if hr.Telemetry != nil {
+		telemetry = *hr.Telemetry
+	}
+	if telemetry.Meter == nil {
+		telemetry.Meter = h
+	} else {
+		telemetry.Meter = meters{telemetry.Meter, h}
+	}
+	hr.Telemetry = &telemetry
+}
+
+// RegisterSession arranges for h to report the work done by
+// session.  An error is returned if session does not count its work,
+// i.e., it was not returned by NewSession or NewTokenSession.
+func (h *MetricsHandler) RegisterSession(session Session) error {
+	s, ok := session.(statsSession)
+	if !ok {
+		return fmt.Errorf("unable to collect metrics from %T: it does not count its work", session)
+	}
+	h.mu.Lock()
+	h.sessions = append(h.sessions, s)
+	h.mu.Unlock()
+	return nil
+}
+
+// Add implements Meter, counting requests, timeouts and challenges.
+// Other metrics are ignored.
+func (h *MetricsHandler) Add(ctx context.Context, name string, n int64, attrs ...Attribute) {
+	value := func(key string) string {
+		for _, attr := range attrs {
+			if attr.Key == key {
+				return fmt.Sprint(attr.Value)
+			}
+		}
+		return ""
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch name {
+	case MetricRequests:
+		status := value("http.response.status_code")
+		if status == "" {
+			status = "error"
+		}
+		h.requests[[3]string{value("server.address"), value("http.request.method"), status}] += uint64(n)
+	case MetricTimeouts:
+		h.timeouts[value(AttributeTimeoutPhase)] += uint64(n)
+	case MetricAuthChallenges:
+		h.challenges[value(AttributeAuthScheme)] += uint64(n)
+	}
+}
+
+// Record implements Meter.  Histograms are not collected.
+func (h *MetricsHandler) Record(ctx context.Context, name string, v float64, attrs ...Attribute) {
+}
+
+// ServeHTTP writes the metrics collected by h.
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteMetrics(w, h)
+}
+
+// MetricFamily is a counter collected by a MetricsHandler, and its
+// samples.  Labels names the labels of each sample, beyond the client
+// label carrying the MetricsHandler name.
+type MetricFamily struct {
+	Name    string
+	Help    string
+	Labels  []string
+	Samples []MetricSample
+}
+
+// MetricSample is a sample of a MetricFamily, with the value of each
+// of its Labels in turn.
+type MetricSample struct {
+	Values []string
+	Value  uint64
+}
+
+// Name returns the name h labels its samples with, as client="name".
+func (h *MetricsHandler) Name() string {
+	return h.name
+}
+
+// Families returns the counters collected by h, each of which is
+// returned whether or not it has samples.  The Session counters have
+// samples once a Session has been registered.
+func (h *MetricsHandler) Families() []MetricFamily {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	requests := MetricFamily{Name: "httpclient_requests_total", Help: "Requests sent, by host, method and status.", Labels: []string{"host", "method", "status"}}
+	for k, n := range h.requests {
+		requests.Samples = append(requests.Samples, MetricSample{[]string{k[0], k[1], k[2]}, n})
+	}
+
+	timeouts := MetricFamily{Name: "httpclient_timeouts_total", Help: "Timeouts fired, by phase.", Labels: []string{"phase"}}
+	for phase, n := range h.timeouts {
+		timeouts.Samples = append(timeouts.Samples, MetricSample{[]string{phase}, n})
+	}
+
+	challenges := MetricFamily{Name: "httpclient_auth_challenges_total", Help: "Authentication challenges received, by scheme.", Labels: []string{"scheme"}}
+	for scheme, n := range h.challenges {
+		challenges.Samples = append(challenges.Samples, MetricSample{[]string{scheme}, n})
+	}
+
+	var stats SessionStats
+	for _, s := range h.sessions {
+		v := s.Stats()
+		stats.AuthCacheHits += v.AuthCacheHits
+		stats.AuthCacheMisses += v.AuthCacheMisses
+		stats.NonceEvictions += v.NonceEvictions
+		stats.Spills += v.Spills
+		stats.SpilledBytes += v.SpilledBytes
+	}
+
+	// sample returns the sample of a Session counter, if any
+	// Session has been registered
+	sample := func(n uint64) []MetricSample {
+		if len(h.sessions) == 0 {
+			return nil
+		}
+		return []MetricSample{{nil, n}}
+	}
+
+	return []MetricFamily{
+		requests,
+		timeouts,
+		challenges,
+		{"httpclient_auth_cache_hits_total", "AuthCache lookups that found a challenge.", nil, sample(stats.AuthCacheHits)},
+		{"httpclient_auth_cache_misses_total", "AuthCache lookups that found no challenge.", nil, sample(stats.AuthCacheMisses)},
+		{"httpclient_nonce_evictions_total", "Nonces evicted from the NonceCounter.", nil, sample(stats.NonceEvictions)},
+		{"httpclient_body_spills_total", "Body copies spilled to a temporary file.", nil, sample(stats.Spills)},
+		{"httpclient_body_spilled_bytes_total", "Bytes of body copies spilled to temporary files.", nil, sample(stats.SpilledBytes)},
+	}
+}
+
+// WriteMetrics writes the metrics collected by each of handlers to
+// w, in the Prometheus text exposition format.
+func WriteMetrics(w io.Writer, handlers ...*MetricsHandler) error {
+	var families []MetricFamily
+	lines := make(map[string][]string)
+	for _, h := range handlers {
+		for _, f := range h.Families() {
+			if _, ok := lines[f.Name]; !ok {
+				lines[f.Name] = []string{}
+				families = append(families, f)
+			}
+			for _, sample := range f.Samples {
+				labels := []string{"client", h.name}
+				for i, name := range f.Labels {
+					labels = append(labels, name, sample.Values[i])
+				}
+				lines[f.Name] = append(lines[f.Name], f.Name+formatLabels(labels)+" "+strconv.FormatUint(sample.Value, 10))
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		sort.Strings(lines[f.Name])
+
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, f.Help)
+		fmt.Fprintf(bw, "# TYPE %s counter\n", f.Name)
+		for _, line := range lines[f.Name] {
+			bw.WriteString(line)
+			bw.WriteString("\n")
+		}
+	}
+	return bw.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels returns the label pairs in labels, given as name and
+// value in turn, in the exposition format.
+func formatLabels(labels []string) string {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,163 @@
+package httpclient
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	handler := newDigestTestHandler("nonce-1")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	credentials := &OrderedCredentials{[]Credential{NewCredential("", "", handler.username, handler.password)}}
+
+	// a nonce cap of 1 evicts each nonce but the last, here "a",
+	// "b", and then the server nonce, and a limit of 8 bytes spills
+	// request bodies to a temporary file
+	session := NewSession(credentials, 1, "", 8)
+	session.Counter("a")
+	session.Counter("b")
+
+	a, b := NewMetricsHandler("a"), NewMetricsHandler("b")
+
+	client := New(WithTimeouts(Timeouts{ResponseHeader: 10 * time.Millisecond}))
+	a.Register(client)
+	if err := a.RegisterSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	other := New()
+	b.Register(other)
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("POST", server.URL+"/dir/index.html", strings.NewReader("a body long enough to spill"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp, err := client.DoAuth(req, session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+	}
+
+	req, _ := http.NewRequest("GET", slow.URL+"/", nil)
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("expected a timeout")
+	}
+
+	req, _ = http.NewRequest("GET", server.URL+"/", nil)
+	rsp, err := other.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	buf := &bytes.Buffer{}
+	if err := WriteMetrics(buf, a, b); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	host, _ := url.Parse(server.URL)
+	slowHost, _ := url.Parse(slow.URL)
+
+	expected := []string{
+		"# TYPE httpclient_requests_total counter",
+		`httpclient_requests_total{client="a",host="` + host.Hostname() + `",method="POST",status="200"} 2`,
+		`httpclient_requests_total{client="a",host="` + host.Hostname() + `",method="POST",status="401"} 1`,
+		`httpclient_requests_total{client="a",host="` + slowHost.Hostname() + `",method="GET",status="error"} 1`,
+		`httpclient_requests_total{client="b",host="` + host.Hostname() + `",method="GET",status="401"} 1`,
+		`httpclient_timeouts_total{client="a",phase="response headers"} 1`,
+		`httpclient_auth_challenges_total{client="a",scheme="Digest"} 1`,
+		`httpclient_auth_cache_hits_total{client="a"} 1`,
+		`httpclient_auth_cache_misses_total{client="a"} 1`,
+		`httpclient_nonce_evictions_total{client="a"} 2`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected line %s in\n%s", line, out)
+		}
+	}
+
+	if strings.Count(out, "# TYPE httpclient_requests_total") != 1 {
+		t.Errorf("expected the families of each MetricsHandler to be merged, got\n%s", out)
+	}
+	if strings.Contains(out, `httpclient_body_spills_total{client="a"} 0`) || strings.Contains(out, `httpclient_body_spilled_bytes_total{client="a"} 0`) {
+		t.Errorf("expected body spills to be counted, got\n%s", out)
+	}
+	if strings.Contains(out, `httpclient_auth_cache_hits_total{client="b"}`) {
+		t.Errorf("expected no session metrics from b, got\n%s", out)
+	}
+}
+
+// TestMetricsHandlerRegister checks the Tracer and Meter set on a
+// Client are kept when it is registered.
+func TestMetricsHandlerRegister(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	m := newMemoryTelemetry()
+	telemetry := &Telemetry{Tracer: m, Meter: m}
+
+	client := New(WithTelemetry(telemetry))
+	h := NewMetricsHandler("a")
+	h.Register(client)
+
+	if client.Telemetry.Tracer != m {
+		t.Errorf("expected the Tracer to be kept")
+	}
+	if telemetry.Meter != m {
+		t.Errorf("expected the Telemetry passed to WithTelemetry to be left unmodified")
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/", nil)
+	rsp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	m.Lock()
+	if n := m.counters[MetricRequests+" http.response.status_code=200"]; n != 1 {
+		t.Errorf("expected 1 request counted by the Meter, got %d", n)
+	}
+	m.Unlock()
+
+	buf := &bytes.Buffer{}
+	if err := WriteMetrics(buf, h); err != nil {
+		t.Fatal(err)
+	}
+	host, _ := url.Parse(server.URL)
+	line := `httpclient_requests_total{client="a",host="` + host.Hostname() + `",method="GET",status="200"} 1`
+	if !strings.Contains(buf.String(), line+"\n") {
+		t.Errorf("expected line %s in\n%s", line, buf.String())
+	}
+}
+
+func TestMetricsHandlerRegisterSession(t *testing.T) {
+	c := NewMetricsHandler("c")
+	if err := c.RegisterSession(struct{ Session }{}); err == nil {
+		t.Errorf("expected an error registering a Session that does not count its work")
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	s := formatLabels([]string{"a", `x"y\z` + "\n", "b", ""})
+	if s != `{a="x\"y\\z\n",b=""}` {
+		t.Errorf("unexpected labels %s", s)
+	}
+}
@@ -0,0 +1,255 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Middleware wraps an http.RoundTripper with additional behavior.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to the http.RoundTripper
+// interface.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Chain returns base wrapped by each of middleware in turn, so that
+// the first middleware sees a request first.  If base is nil,
+// http.DefaultTransport is used.
+func Chain(base http.RoundTripper, middleware ...Middleware) http.RoundTripper {
+	rt := base
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		rt = middleware[i](rt)
+	}
+	return rt
+}
+
+// WithMiddleware adds middleware to the chain each request sent by
+// the Client passes through, after signing, retries, rate limiting,
+// circuit breaking and timeouts have been applied.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(hr *Client) {
+		hr.Middleware = append(hr.Middleware, middleware...)
+	}
+}
+
+// base returns rt, or http.DefaultTransport if rt is nil
+func base(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		return http.DefaultTransport
+	}
+	return rt
+}
+
+// TimeoutTransport limits a round trip, up to the close of the
+// response body, to Timeout, and fails reads of the response body if
+// BodyIdle elapses between them.  A timeout is reported as a
+// *TimeoutError naming the phase of the request that expired.
+type TimeoutTransport struct {
+	Base     http.RoundTripper
+	Timeout  time.Duration
+	BodyIdle time.Duration
+}
+
+// Timeout returns Middleware applying a TimeoutTransport
+func Timeout(timeout, bodyIdle time.Duration) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &TimeoutTransport{Base: next, Timeout: timeout, BodyIdle: bodyIdle}
+	}
+}
+
+func (t *TimeoutTransport) RoundTrip(req *http.Request) (rsp *http.Response, err error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if t.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), t.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(req.Context())
+	}
+
+	start := time.Now()
+
+	phase := &phaseTracker{}
+
+	rsp, err = base(t.Base).RoundTrip(req.WithContext(phase.trace(ctx)))
+	if err != nil {
+		cancel()
+		if isTimeout(err) {
+			terr := &TimeoutError{
+				URL:     req.URL.String(),
+				Phase:   phase.get(),
+				Elapsed: time.Since(start),
+				Err:     err,
+			}
+			hooksFrom(ctx).timeout(req, terr)
+			telemetryFrom(ctx).timeout(req, terr)
+			err = terr
+		}
+		return
+	}
+
+	rsp.Body = newDeadlineReadCloser(ctx, cancel, req, start, rsp.Body, t.BodyIdle)
+
+	return rsp, nil
+}
+
+// RetryTransport retries failed round trips as Policy directs,
+// replaying request bodies via Session if it is set.
+type RetryTransport struct {
+	Base    http.RoundTripper
+	Policy  *RetryPolicy
+	Session Session
+}
+
+// Retry returns Middleware applying a RetryTransport
+func Retry(policy *RetryPolicy, session Session) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &RetryTransport{Base: next, Policy: policy, Session: session}
+	}
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the policy replaces the body of the request it retries
+	req = req.Clone(req.Context())
+	return t.Policy.do(req.Context(), req, t.Session, func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return base(t.Base).RoundTrip(req.WithContext(ctx))
+	})
+}
+
+// SignTransport signs each request with Signer before sending it.
+type SignTransport struct {
+	Base   http.RoundTripper
+	Signer Signer
+}
+
+// Sign returns Middleware applying a SignTransport
+func Sign(signer Signer) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &SignTransport{Base: next, Signer: signer}
+	}
+}
+
+func (t *SignTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	err := t.Signer.Sign(req)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return base(t.Base).RoundTrip(req)
+}
+
+// LimitTransport waits for Limiter to allow each request, and adapts
+// the limit to the rate limit headers of each response.
+type LimitTransport struct {
+	Base    http.RoundTripper
+	Limiter *RateLimiter
+}
+
+// Limit returns Middleware applying a LimitTransport
+func Limit(limiter *RateLimiter) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &LimitTransport{Base: next, Limiter: limiter}
+	}
+}
+
+func (t *LimitTransport) RoundTrip(req *http.Request) (rsp *http.Response, err error) {
+	err = t.Limiter.wait(req.Context(), req)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return
+	}
+
+	rsp, err = base(t.Base).RoundTrip(req)
+	if err == nil {
+		t.Limiter.observe(req, rsp)
+	}
+
+	return
+}
+
+// BreakerTransport fails requests fast while Breaker holds the
+// circuit for their host open, and records the outcome of each.
+type BreakerTransport struct {
+	Base    http.RoundTripper
+	Breaker *CircuitBreaker
+}
+
+// Break returns Middleware applying a BreakerTransport
+func Break(breaker *CircuitBreaker) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &BreakerTransport{Base: next, Breaker: breaker}
+	}
+}
+
+func (t *BreakerTransport) RoundTrip(req *http.Request) (rsp *http.Response, err error) {
+	done, err := t.Breaker.allow(req.URL.Host)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return
+	}
+
+	rsp, err = base(t.Base).RoundTrip(req)
+	done(rsp, err)
+
+	return
+}
+
+// BulkheadTransport limits the requests in flight to each host, as
+// configured by Bulkhead.  A request holds its place until the
+// response body is closed.
+type BulkheadTransport struct {
+	Base     http.RoundTripper
+	Bulkhead *Bulkhead
+}
+
+// Isolate returns Middleware applying a BulkheadTransport
+func Isolate(bulkhead *Bulkhead) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &BulkheadTransport{Base: next, Bulkhead: bulkhead}
+	}
+}
+
+func (t *BulkheadTransport) RoundTrip(req *http.Request) (rsp *http.Response, err error) {
+	release, err := t.Bulkhead.acquire(req.Context(), req)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return
+	}
+
+	rsp, err = base(t.Base).RoundTrip(req)
+	if err != nil {
+		release()
+		return
+	}
+
+	rsp.Body = &releaseReadCloser{ReadCloser: rsp.Body, release: release}
+
+	return rsp, nil
+}
+
+// clientTransport adapts an http.Client to the http.RoundTripper
+// interface, so that the Client middleware wraps the whole of an
+// exchange, including redirects and cookies.
+type clientTransport struct {
+	client *http.Client
+}
+
+func (t clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.client.Do(req)
+}
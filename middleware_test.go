@@ -0,0 +1,128 @@
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	base := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		order = append(order, "base")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	_, err := Chain(base, mark("a"), mark("b"), mark("c")).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a", "b", "c", "base"}
+	if len(order) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Errorf("%d: expected %s, got %s", i, expected[i], order[i])
+		}
+	}
+}
+
+// TestTransports uses the middleware with a plain http.Client, as
+// code expecting an http.RoundTripper would.
+func TestTransports(t *testing.T) {
+	handler := &flakyTestHandler{failures: 1, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var mu sync.Mutex
+	var methods []string
+	observe := func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			methods = append(methods, req.Method)
+			mu.Unlock()
+			return next.RoundTrip(req)
+		})
+	}
+
+	client := &http.Client{
+		Transport: Chain(http.DefaultTransport,
+			Retry(&RetryPolicy{jitter: func(d time.Duration) time.Duration { return 0 }}, nil),
+			Limit(NewRateLimiter(100, 10)),
+			Break(&CircuitBreaker{}),
+			Isolate(NewBulkhead(4, 0)),
+			Timeout(time.Second, 0),
+			observe,
+		),
+	}
+
+	rsp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 after a retry, got %d", rsp.StatusCode)
+	}
+	if len(methods) != 2 {
+		t.Errorf("expected 2 attempts, got %d", len(methods))
+	}
+
+	slow := httptest.NewServer(&httpTestHandler{Mutex: new(sync.Mutex)})
+	defer slow.Close()
+
+	client.Transport = Chain(nil, Timeout(100*time.Millisecond, 0))
+
+	req, _ := http.NewRequest("GET", slow.URL, nil)
+	req.Header.Add(delayHeader, "1000000000")
+	_, err = client.Do(req)
+
+	var terr *TimeoutError
+	if !errors.As(err, &terr) || terr.Phase != PhaseHeaders {
+		t.Errorf("expected a response headers TimeoutError, got %v", err)
+	}
+}
+
+func TestWithMiddleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Header.Get("X-Request-Id")))
+	}))
+	defer server.Close()
+
+	client := New(WithMiddleware(func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.Header.Set("X-Request-Id", "42")
+			return next.RoundTrip(req)
+		})
+	}))
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	rsp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	b := make([]byte, 2)
+	n, _ := rsp.Body.Read(b)
+	if string(b[:n]) != "42" {
+		t.Errorf("expected the middleware to set X-Request-Id, got %q", string(b[:n]))
+	}
+}
@@ -0,0 +1,177 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultTokenLeeway is the time before a token expires at which
+// ClientCredentials will request a replacement.
+var DefaultTokenLeeway = 30 * time.Second
+
+// DefaultTokenTimeout limits each request ClientCredentials makes to
+// the token endpoint.
+var DefaultTokenTimeout = 30 * time.Second
+
+// ClientCredentials implements ExpiringTokenSource using the OAuth2
+// client_credentials grant (RFC 6749 4.4).  The client id and secret
+// are looked up via Credentials.Login, keyed by the token endpoint
+// URL.
+//
+// ClientCredentials does not cache tokens itself: handed to
+// NewTokenSession, its tokens are cached in the Session until shortly
+// before they expire, and concurrent requests for the same scope
+// share a single call to the token endpoint.  Each Session holds
+// tokens of its own.
+type ClientCredentials struct {
+	client      *Client
+	tokenURL    *url.URL
+	credentials Credentials
+	scope       []string
+
+	// Leeway sets how long before expiry a token is replaced.
+	Leeway time.Duration
+
+	// Timeout limits each request to the token endpoint.
+	Timeout time.Duration
+
+	// now returns the current time, and may be replaced in tests
+	now func() time.Time
+}
+
+// NewClientCredentials returns a ClientCredentials that requests
+// tokens from tokenURL using client, authenticating with the client
+// id and secret that credentials returns for tokenURL.  scope is
+// requested when the Bearer challenge does not name one.
+func NewClientCredentials(client *Client, tokenURL string, credentials Credentials, scope ...string) (cc *ClientCredentials, err error) {
+	uri, err := url.Parse(tokenURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse token endpoint %s: %v", tokenURL, err)
+	}
+
+	cc = &ClientCredentials{
+		client:      client,
+		tokenURL:    uri,
+		credentials: credentials,
+		scope:       scope,
+		Leeway:      DefaultTokenLeeway,
+		Timeout:     DefaultTokenTimeout,
+		now:         time.Now,
+	}
+
+	return cc, nil
+}
+
+// Token requests a new token from the token endpoint on each call.
+func (cc *ClientCredentials) Token(uri *url.URL, realm string, scope []string, refresh bool) (token string, err error) {
+	token, _, err = cc.NewToken(uri, realm, scope)
+	return
+}
+
+// NewToken requests a new token from the token endpoint, returning
+// it with its expiry less Leeway.
+func (cc *ClientCredentials) NewToken(uri *url.URL, realm string, scope []string) (token string, expiry time.Time, err error) {
+	if len(scope) == 0 {
+		scope = cc.scope
+	}
+
+	token, expiry, err = cc.fetch(scope)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if !expiry.IsZero() {
+		expiry = expiry.Add(-cc.Leeway)
+	}
+
+	return token, expiry, nil
+}
+
+// fetch requests a new access token for scope from the token
+// endpoint, giving up after Timeout.  The request is not tied to the
+// context of any one caller, as several may be waiting on it.  A zero
+// expiry indicates that the server did not send expires_in.
+func (cc *ClientCredentials) fetch(scope []string) (token string, expiry time.Time, err error) {
+	id, secret, err := cc.credentials.Login(cc.tokenURL, "")
+	if err != nil {
+		return
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(scope) > 0 {
+		form.Set("scope", strings.Join(scope, " "))
+	}
+
+	req, err := http.NewRequest("POST", cc.tokenURL.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if cc.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), cc.Timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	// RFC 6749 2.3.1 requires the id and secret be form encoded
+	req.SetBasicAuth(url.QueryEscape(id), url.QueryEscape(secret))
+
+	issued := cc.now()
+
+	rsp, err := cc.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer rsp.Body.Close()
+
+	b, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		err = fmt.Errorf("error reading %s token response: %v", cc.tokenURL, err)
+		return
+	}
+
+	var v struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	err = json.Unmarshal(b, &v)
+	if err != nil && rsp.StatusCode == http.StatusOK {
+		err = fmt.Errorf("unable to parse %s token response: %v", cc.tokenURL, err)
+		return
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("error requesting token from %s: %s %s %s",
+			cc.tokenURL, rsp.Status, v.Error, v.ErrorDescription)
+		return
+	}
+
+	if v.AccessToken == "" {
+		err = fmt.Errorf("token response from %s is missing access_token", cc.tokenURL)
+		return
+	}
+
+	if v.TokenType != "" && !strings.EqualFold(v.TokenType, "Bearer") {
+		err = fmt.Errorf("unsupported token_type from %s: %s", cc.tokenURL, v.TokenType)
+		return
+	}
+
+	token = v.AccessToken
+	if v.ExpiresIn > 0 {
+		expiry = issued.Add(time.Duration(v.ExpiresIn) * time.Second)
+	}
+
+	return token, expiry, nil
+}
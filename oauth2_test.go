@@ -0,0 +1,231 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tokenTestHandler implements an OAuth2 token endpoint issuing
+// numbered tokens for the client_credentials grant.
+type tokenTestHandler struct {
+	sync.Mutex
+	id     string
+	secret string
+	delay  time.Duration
+	count  int
+	scope  []string
+}
+
+func (h *tokenTestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// RFC 6749 2.3.1 requires the id and secret be form encoded
+	id, secret, ok := req.BasicAuth()
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	if !ok || id != h.id || secret != h.secret {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	if req.FormValue("grant_type") != "client_credentials" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	if h.delay > 0 {
+		<-time.After(h.delay)
+	}
+
+	h.Lock()
+	h.count++
+	n := h.count
+	h.scope = append(h.scope, req.FormValue("scope"))
+	h.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": fmt.Sprintf("token-%d", n),
+		"token_type":   "bearer",
+		"expires_in":   3600,
+	})
+}
+
+func newTokenTestSource(t *testing.T, handler *tokenTestHandler) (*ClientCredentials, *httptest.Server) {
+	server := httptest.NewServer(handler)
+
+	credentials := &OrderedCredentials{[]Credential{NewCredential("", "/token", handler.id, handler.secret)}}
+
+	cc, err := NewClientCredentials(NewClient(time.Duration(5*time.Second)), server.URL+"/token", credentials, "read")
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return cc, server
+}
+
+// newTokenTestSession returns a Session caching the tokens of cc,
+// whose clock is now.
+func newTokenTestSession(cc *ClientCredentials, now func() time.Time) *session {
+	s := NewTokenSession(nil, cc, 1000, "", -1).(*session)
+	cc.now, s.now = now, now
+	return s
+}
+
+func TestClientCredentials(t *testing.T) {
+	handler := &tokenTestHandler{id: "client id", secret: "s3cr&t"}
+
+	cc, server := newTokenTestSource(t, handler)
+	defer server.Close()
+
+	now := time.Now()
+	session := newTokenTestSession(cc, func() time.Time { return now })
+
+	tests := []struct {
+		Advance time.Duration
+		Refresh bool
+		Token   string
+	}{
+		{0, false, "token-1"},
+		{time.Minute, false, "token-1"},
+		{time.Hour - time.Minute - DefaultTokenLeeway/2, false, "token-2"},
+		{time.Minute, true, "token-3"},
+		{time.Minute, false, "token-3"},
+	}
+
+	for i, v := range tests {
+		now = now.Add(v.Advance)
+
+		token, err := session.Token(nil, "", nil, v.Refresh)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if token != v.Token {
+			t.Errorf("%d: expected %s, got %s", i, v.Token, token)
+		}
+	}
+
+	if handler.scope[0] != "read" {
+		t.Errorf("expected default scope read, got %q", handler.scope[0])
+	}
+}
+
+// TestClientCredentialsSessions checks each Session caches tokens of
+// its own.
+func TestClientCredentialsSessions(t *testing.T) {
+	handler := &tokenTestHandler{id: "client", secret: "secret"}
+
+	cc, server := newTokenTestSource(t, handler)
+	defer server.Close()
+
+	a := NewTokenSession(nil, cc, 1000, "", -1)
+	b := NewTokenSession(nil, cc, 1000, "", -1)
+
+	tests := []struct {
+		Session Session
+		Token   string
+	}{
+		{a, "token-1"},
+		{b, "token-2"},
+		{a, "token-1"},
+		{b, "token-2"},
+		{NewTokenSession(nil, cc, 1000, "", -1), "token-3"},
+	}
+
+	for i, v := range tests {
+		token, err := v.Session.Token(nil, "", nil, false)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if token != v.Token {
+			t.Errorf("%d: expected %s, got %s", i, v.Token, token)
+		}
+	}
+
+	// without a Session, each call requests a new token
+	if token, err := cc.Token(nil, "", nil, false); err != nil || token != "token-4" {
+		t.Errorf("expected token-4, got %s, %v", token, err)
+	}
+}
+
+func TestClientCredentialsConcurrent(t *testing.T) {
+	handler := &tokenTestHandler{id: "client", secret: "secret", delay: 50 * time.Millisecond}
+
+	cc, server := newTokenTestSource(t, handler)
+	defer server.Close()
+
+	session := NewTokenSession(nil, cc, 1000, "", -1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := session.Token(nil, "", []string{"write"}, false)
+			if err != nil {
+				t.Error(err)
+			} else if token != "token-1" {
+				t.Errorf("expected token-1, got %s", token)
+			}
+		}()
+	}
+	wg.Wait()
+
+	handler.Lock()
+	defer handler.Unlock()
+	if handler.count != 1 {
+		t.Errorf("expected 1 token request, got %d", handler.count)
+	}
+}
+
+func TestClientCredentialsInvalidClient(t *testing.T) {
+	handler := &tokenTestHandler{id: "client", secret: "secret"}
+
+	cc, server := newTokenTestSource(t, handler)
+	defer server.Close()
+
+	handler.secret = "changed"
+
+	_, err := NewTokenSession(nil, cc, 1000, "", -1).Token(nil, "", nil, false)
+	if err == nil {
+		t.Error("expected an error for an invalid client")
+	}
+}
+
+func TestClientCredentialsContext(t *testing.T) {
+	handler := &tokenTestHandler{id: "client", secret: "secret", delay: 300 * time.Millisecond}
+
+	cc, server := newTokenTestSource(t, handler)
+	defer server.Close()
+
+	cc.Timeout = 100 * time.Millisecond
+	session := NewTokenSession(nil, cc, 1000, "", -1).(*session)
+
+	// a caller giving up does not wait on the token endpoint
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := session.TokenContext(ctx, nil, "", nil, false)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 80*time.Millisecond {
+		t.Errorf("expected TokenContext to return when ctx was done, took %s", elapsed)
+	}
+
+	// while the request itself gives up after Timeout
+	_, err = session.Token(nil, "", nil, false)
+	if !isTimeout(err) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+)
+
+// Option configures a Client built by New.
+type Option func(hr *Client)
+
+// New returns a Client configured by opts.  Without options, the
+// Client imposes no timeouts and sends requests directly.  Options
+// are applied in order, configuring the Client Transport, which is
+// shared with the embedded http.Client.
+func New(opts ...Option) (hr *Client) {
+	hr = NewClientTimeouts(Timeouts{})
+	for _, opt := range opts {
+		opt(hr)
+	}
+	return
+}
+
+// WithTimeouts sets the time allowed for each phase of a request.
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(hr *Client) {
+		hr.setTimeouts(timeouts)
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used for https requests.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(hr *Client) {
+		hr.Transport.TLSClientConfig = config
+	}
+}
+
+// WithProxy sets the function that selects a proxy for each request,
+// e.g., http.ProxyFromEnvironment or http.ProxyURL.
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) Option {
+	return func(hr *Client) {
+		hr.Transport.Proxy = proxy
+	}
+}
+
+// WithConnPool sets the number of idle connections kept in total and
+// per host, and the number of connections allowed per host.  Zero
+// leaves the http.Transport default.
+func WithConnPool(maxIdle, maxIdlePerHost, maxPerHost int) Option {
+	return func(hr *Client) {
+		hr.Transport.MaxIdleConns = maxIdle
+		hr.Transport.MaxIdleConnsPerHost = maxIdlePerHost
+		hr.Transport.MaxConnsPerHost = maxPerHost
+	}
+}
+
+// WithJar sets the cookie jar.
+func WithJar(jar http.CookieJar) Option {
+	return func(hr *Client) {
+		hr.Jar = jar
+	}
+}
+
+// WithRedirect sets the redirect policy, as for http.Client
+// CheckRedirect.
+func WithRedirect(checkRedirect func(req *http.Request, via []*http.Request) error) Option {
+	return func(hr *Client) {
+		hr.CheckRedirect = checkRedirect
+	}
+}
+
+// WithSession sets the Session DoAuth uses when called without one.
+func WithSession(session Session) Option {
+	return func(hr *Client) {
+		hr.Session = session
+	}
+}
+
+// WithSigner sets the Signer applied to each request.
+func WithSigner(signer Signer) Option {
+	return func(hr *Client) {
+		hr.Signer = signer
+	}
+}
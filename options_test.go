@@ -0,0 +1,101 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, req *http.Request) {
+		if username, password, ok := req.BasicAuth(); !ok || username != "user" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "1", Path: "/"})
+		http.Redirect(w, req, "/home", http.StatusFound)
+	})
+	mux.HandleFunc("/home", func(w http.ResponseWriter, req *http.Request) {
+		if c, err := req.Cookie("session"); err != nil || c.Value != "1" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentials := &OrderedCredentials{[]Credential{NewCredential("", "", "user", "secret")}}
+
+	client := New(
+		WithTimeouts(Timeouts{Total: 5 * time.Second, BodyIdle: time.Second}),
+		WithTLSConfig(server.Client().Transport.(*http.Transport).TLSClientConfig),
+		WithConnPool(10, 2, 4),
+		WithJar(jar),
+		WithRedirect(func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}),
+		WithSession(NewSession(credentials, 1000, "", -1)),
+	)
+
+	if client.Client.Transport != client.Transport {
+		t.Error("expected the http.Client and Client to share a Transport")
+	}
+	if client.Timeout != 5*time.Second || client.BodyIdleTimeout != time.Second {
+		t.Errorf("unexpected timeouts %s, %s", client.Timeout, client.BodyIdleTimeout)
+	}
+	if client.Transport.MaxIdleConns != 10 || client.Transport.MaxIdleConnsPerHost != 2 || client.Transport.MaxConnsPerHost != 4 {
+		t.Error("unexpected connection pool sizes")
+	}
+
+	tests := []struct {
+		Path   string
+		Status int
+	}{
+		{"/login", http.StatusFound},
+		{"/home", http.StatusOK},
+	}
+
+	for i, v := range tests {
+		req, err := http.NewRequest("GET", server.URL+v.Path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := client.DoAuth(req, nil)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		rsp.Body.Close()
+
+		if rsp.StatusCode != v.Status {
+			t.Errorf("%d: expected status %d, got %d", i, v.Status, rsp.StatusCode)
+		}
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	client := New()
+
+	if client.Timeout != 0 || client.Transport.ResponseHeaderTimeout != 0 {
+		t.Error("expected no timeouts by default")
+	}
+	if client.Transport.Proxy != nil {
+		t.Error("expected no proxy by default")
+	}
+
+	config := &tls.Config{ServerName: "example.com"}
+	client = New(WithTLSConfig(config), WithProxy(http.ProxyFromEnvironment))
+	if client.Transport.TLSClientConfig != config || client.Transport.Proxy == nil {
+		t.Error("expected the TLS config and proxy to be set")
+	}
+}
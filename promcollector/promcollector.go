@@ -0,0 +1,44 @@
+// Package promcollector adapts an httpclient.MetricsHandler to a
+// prometheus.Collector, so that the counters it collects may be
+// added to a Prometheus registry.
+package promcollector
+
+import (
+	"github.com/jimrobinson/httpclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector reporting the counters of a
+// MetricsHandler.  Each sample carries a constant client label with
+// the MetricsHandler name, so that the Collectors of several Clients
+// may be registered together.
+type Collector struct {
+	handler *httpclient.MetricsHandler
+}
+
+// New returns a Collector reporting the counters of h.
+func New(h *httpclient.MetricsHandler) *Collector {
+	return &Collector{handler: h}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, f := range c.handler.Families() {
+		ch <- c.desc(f)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, f := range c.handler.Families() {
+		desc := c.desc(f)
+		for _, s := range f.Samples {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(s.Value), s.Values...)
+		}
+	}
+}
+
+// desc returns the descriptor of f.
+func (c *Collector) desc(f httpclient.MetricFamily) *prometheus.Desc {
+	return prometheus.NewDesc(f.Name, f.Help, f.Labels, prometheus.Labels{"client": c.handler.Name()})
+}
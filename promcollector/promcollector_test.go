@@ -0,0 +1,48 @@
+package promcollector
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jimrobinson/httpclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	h := httpclient.NewMetricsHandler("a")
+	h.Add(context.Background(), httpclient.MetricRequests, 2,
+		httpclient.Attribute{Key: "server.address", Value: "example.com"},
+		httpclient.Attribute{Key: "http.request.method", Value: "GET"},
+		httpclient.Attribute{Key: "http.response.status_code", Value: 200})
+	h.Add(context.Background(), httpclient.MetricRequests, 1,
+		httpclient.Attribute{Key: "server.address", Value: "example.com"},
+		httpclient.Attribute{Key: "http.request.method", Value: "GET"})
+	h.Add(context.Background(), httpclient.MetricAuthChallenges, 1,
+		httpclient.Attribute{Key: httpclient.AttributeAuthScheme, Value: "Digest"})
+
+	c := New(h)
+
+	descs := make(chan *prometheus.Desc, 16)
+	c.Describe(descs)
+	close(descs)
+	if n := len(descs); n != len(h.Families()) {
+		t.Errorf("expected %d descriptors, got %d", len(h.Families()), n)
+	}
+
+	expected := `
+# HELP httpclient_auth_challenges_total Authentication challenges received, by scheme.
+# TYPE httpclient_auth_challenges_total counter
+httpclient_auth_challenges_total{client="a",scheme="Digest"} 1
+# HELP httpclient_requests_total Requests sent, by host, method and status.
+# TYPE httpclient_requests_total counter
+httpclient_requests_total{client="a",host="example.com",method="GET",status="200"} 2
+httpclient_requests_total{client="a",host="example.com",method="GET",status="error"} 1
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"httpclient_auth_challenges_total", "httpclient_requests_total", "httpclient_auth_cache_hits_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
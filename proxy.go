@@ -0,0 +1,146 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// proxyTunnelKey is the context key under which DoAuth stores the
+// *proxyTunnel for a request.
+type proxyTunnelKey struct{}
+
+// proxyTunnel carries the state needed to answer a proxy challenge
+// to the CONNECT request the Transport sends on our behalf when
+// tunneling an https request through a proxy.  The Transport
+// consults it via proxyConnectHeader and proxyConnectResponse.
+type proxyTunnel struct {
+	sync.Mutex
+	session Session
+
+	// challenges holds the challenges sent in a 407 response
+	// to the last CONNECT request.
+	challenges Challenges
+
+	// challenge is the challenge to answer in the next CONNECT
+	// request, overriding any cached for the proxy.
+	challenge *Challenge
+
+	// answered and auth record the challenge answered in the
+	// last CONNECT request, and the Proxy-Authorization sent.
+	answered *Challenge
+	auth     string
+}
+
+// challenged returns and clears the challenges sent in a 407
+// response to the last CONNECT request.
+func (tunnel *proxyTunnel) challenged() (challenges Challenges) {
+	tunnel.Lock()
+	defer tunnel.Unlock()
+	challenges, tunnel.challenges = tunnel.challenges, nil
+	return
+}
+
+// answer arranges for the next CONNECT request to answer challenge
+func (tunnel *proxyTunnel) answer(challenge *Challenge) {
+	tunnel.Lock()
+	defer tunnel.Unlock()
+	tunnel.challenge = challenge
+}
+
+// SetProxyConnectHooks sets the GetProxyConnectHeader and
+// OnProxyConnectResponse hooks of transport, so that an AuthTransport
+// sending requests via transport answers the challenges of a proxy to
+// the CONNECT request for an https tunnel.  NewClient installs them
+// on the Client Transport.
+func SetProxyConnectHooks(transport *http.Transport) {
+	transport.GetProxyConnectHeader = proxyConnectHeader
+	transport.OnProxyConnectResponse = proxyConnectResponse
+}
+
+// proxyConnectHeader implements http.Transport GetProxyConnectHeader,
+// returning a Proxy-Authorization header for the CONNECT request to
+// target, if DoAuth has a challenge from proxy to answer.
+func proxyConnectHeader(ctx context.Context, proxy *url.URL, target string) (http.Header, error) {
+	tunnel, _ := ctx.Value(proxyTunnelKey{}).(*proxyTunnel)
+	if tunnel == nil {
+		return nil, nil
+	}
+
+	tunnel.Lock()
+	defer tunnel.Unlock()
+
+	// the request carries ctx, and so the Hooks of the request
+	// being tunneled
+	req := (&http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}).WithContext(ctx)
+
+	var auth string
+	var err error
+
+	challenge := tunnel.challenge
+	if challenge != nil {
+		auth, err = challenge.Authorization(tunnel.session, req)
+	} else {
+		challenge, auth = tunnel.session.ProxyAuthorization(proxy)
+		auth, err = preemptive(tunnel.session, req, challenge, auth)
+	}
+
+	tunnel.answered, tunnel.auth = nil, ""
+
+	if err == NoCredentialsErr {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else if auth == "" {
+		return nil, nil
+	}
+
+	tunnel.answered, tunnel.auth = challenge, auth
+
+	return http.Header{"Proxy-Authorization": {auth}}, nil
+}
+
+// proxyConnectResponse implements http.Transport
+// OnProxyConnectResponse, recording the challenges sent by the proxy
+// in a 407 response, or caching the answered challenge if the
+// CONNECT request succeeded.
+func proxyConnectResponse(ctx context.Context, proxy *url.URL, connectReq *http.Request, connectRes *http.Response) error {
+	tunnel, _ := ctx.Value(proxyTunnelKey{}).(*proxyTunnel)
+	if tunnel == nil {
+		return nil
+	}
+
+	tunnel.Lock()
+	defer tunnel.Unlock()
+
+	switch connectRes.StatusCode {
+	case http.StatusProxyAuthRequired:
+		challenges, err := ProxyAuthentication(connectRes, proxy)
+		if err != nil {
+			return fmt.Errorf("unable to parse %s Proxy-Authenticate: %v", proxy.String(), err)
+		}
+		tunnel.challenges = challenges.Preferred()
+	case http.StatusOK:
+		if tunnel.answered != nil {
+			tunnel.session.SetProxyAuthorization(proxy, tunnel.answered.Answered(), tunnel.auth)
+		}
+	}
+
+	return nil
+}
+
+// proxyURL returns the proxy the Transport will use for req, or nil
+// if req will be sent directly.
+func (hr *Client) proxyURL(req *http.Request) (*url.URL, error) {
+	if hr.Transport == nil || hr.Transport.Proxy == nil {
+		return nil, nil
+	}
+	return hr.Transport.Proxy(req)
+}
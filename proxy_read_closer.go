@@ -29,6 +29,11 @@ type MemFileReadCloser struct {
 	fh    *os.File
 	dir   string
 	used  bool
+
+	// spilled counts the bytes written to fh, and is reported to
+	// onSpill, if set, on Close.
+	spilled int64
+	onSpill func(n int64)
 }
 
 // NewMemFileReadCloser returns a MemFileReadCLoser that will write
@@ -49,7 +54,9 @@ func NewMemFileReadCloser(dir string, limit int) *MemFileReadCloser {
 // and any error encountered.
 func (w *MemFileReadCloser) Write(p []byte) (n int, err error) {
 	if w.fh != nil {
-		return w.fh.Write(p)
+		n, err = w.fh.Write(p)
+		w.spilled += int64(n)
+		return
 	}
 
 	n, err = w.buf.Write(p)
@@ -63,8 +70,9 @@ func (w *MemFileReadCloser) Write(p []byte) (n int, err error) {
 		return n, err
 	}
 
-	_, err = w.fh.Write(w.buf.Bytes())
+	spilled, err := w.fh.Write(w.buf.Bytes())
 	if err == nil {
+		w.spilled = int64(spilled)
 		w.buf.Reset()
 	} else {
 		w.fh.Close()
@@ -80,6 +88,10 @@ func (w *MemFileReadCloser) Write(p []byte) (n int, err error) {
 func (w *MemFileReadCloser) Close() (err error) {
 	if w.fh != nil {
 		err = w.fh.Close()
+		if w.onSpill != nil {
+			w.onSpill(w.spilled)
+			w.onSpill = nil
+		}
 	}
 	return err
 }
@@ -0,0 +1,189 @@
+package httpclient
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// proxyTestHandler implements a forward proxy requiring Basic
+// Proxy-Authorization.  Plain http requests are answered directly
+// rather than forwarded, while CONNECT requests are tunneled to
+// their target.
+type proxyTestHandler struct {
+	sync.Mutex
+	username string
+	password string
+	auth     []string
+}
+
+func (h *proxyTestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	auth := req.Header.Get("Proxy-Authorization")
+
+	h.Lock()
+	h.auth = append(h.auth, auth)
+	h.Unlock()
+
+	expect := "Basic " + base64.StdEncoding.EncodeToString([]byte(h.username+":"+h.password))
+	if auth != expect {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+
+	if req.Method != "CONNECT" {
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "proxied "+req.URL.String())
+		return
+	}
+
+	dst, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	src, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		dst.Close()
+		return
+	}
+
+	io.WriteString(src, "HTTP/1.1 200 Connection Established\r\n\r\n")
+
+	go func() {
+		io.Copy(dst, src)
+		dst.Close()
+	}()
+	go func() {
+		io.Copy(src, dst)
+		src.Close()
+	}()
+}
+
+func newProxyTestClient(t *testing.T, proxy *httptest.Server, handler *proxyTestHandler) (*Client, Session, *countingCredentials) {
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentials := &countingCredentials{
+		Credentials: &OrderedCredentials{[]Credential{NewCredential(proxyURL.Host, "/", handler.username, handler.password)}},
+	}
+
+	client := NewClient(time.Duration(1 * time.Second))
+	client.Transport.Proxy = http.ProxyURL(proxyURL)
+
+	return client, NewSession(credentials, 1000, "", -1), credentials
+}
+
+func TestDoAuthProxy(t *testing.T) {
+	handler := &proxyTestHandler{username: "proxy", password: "secret"}
+
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	client, session, credentials := newProxyTestClient(t, proxy, handler)
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", "http://example.org/test", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := client.DoAuth(req, session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusOK {
+			t.Fatalf("%d: expected status %d, got %d", i, http.StatusOK, rsp.StatusCode)
+		}
+		if s := string(b); s != "proxied http://example.org/test" {
+			t.Errorf("%d: unexpected body %q", i, s)
+		}
+	}
+
+	// the first request is challenged, the second preemptive
+	if n := len(handler.auth); n != 3 {
+		t.Errorf("expected 3 requests to the proxy, got %d", n)
+	}
+	if credentials.n != 1 {
+		t.Errorf("expected 1 call to Login, got %d", credentials.n)
+	}
+}
+
+func TestDoAuthProxyConnect(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Proxy-Authorization") != "" {
+			t.Errorf("Proxy-Authorization leaked to origin server")
+		}
+		io.WriteString(w, "tunneled")
+	}))
+	defer origin.Close()
+
+	handler := &proxyTestHandler{username: "proxy", password: "secret"}
+
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	client, session, credentials := newProxyTestClient(t, proxy, handler)
+	client.Transport.TLSClientConfig = origin.Client().Transport.(*http.Transport).TLSClientConfig
+
+	recorder := &hooksRecorder{}
+	client.Hooks = &Hooks{
+		CredentialLookup: func(req *http.Request, uri *url.URL, realm, username string, err error, elapsed time.Duration) {
+			recorder.record(elapsed, "login %s %s %v", req.Method, username, err)
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", origin.URL+"/test", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := client.DoAuth(req, session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusOK {
+			t.Fatalf("%d: expected status %d, got %d", i, http.StatusOK, rsp.StatusCode)
+		}
+		if s := string(b); s != "tunneled" {
+			t.Errorf("%d: unexpected body %q", i, s)
+		}
+
+		// force a new CONNECT for the next request
+		client.Transport.CloseIdleConnections()
+	}
+
+	// the first CONNECT is challenged, the second preemptive
+	if n := len(handler.auth); n != 3 {
+		t.Errorf("expected 3 CONNECT requests to the proxy, got %d", n)
+	}
+	if credentials.n != 1 {
+		t.Errorf("expected 1 call to Login, got %d", credentials.n)
+	}
+
+	// the lookup answering the CONNECT challenge is reported
+	recorder.Lock()
+	defer recorder.Unlock()
+	expected := []string{"login CONNECT proxy <nil>"}
+	if strings.Join(recorder.events, "; ") != strings.Join(expected, "; ") {
+		t.Errorf("expected events %q, got %q", expected, recorder.events)
+	}
+}
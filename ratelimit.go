@@ -0,0 +1,290 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitError is returned when a request would have to wait for
+// the rate limiter past the request deadline.
+type RateLimitError struct {
+	Key      string
+	Delay    time.Duration
+	Deadline time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit for %s requires waiting %s, past the deadline at %s",
+		e.Key, e.Delay, e.Deadline.Format(time.RFC3339))
+}
+
+// LimitByHost keys a RateLimiter by the request host.
+func LimitByHost(req *http.Request) string {
+	return req.URL.Host
+}
+
+// LimitByCredential returns a key function that keys a RateLimiter
+// by the username of the Credential matching the request, falling
+// back to the request host if none matches.
+func LimitByCredential(credentials Credentials) func(req *http.Request) string {
+	return func(req *http.Request) string {
+		username, _, err := credentials.Login(req.URL, "")
+		if err != nil || username == "" {
+			return req.URL.Host
+		}
+		return "credential:" + username
+	}
+}
+
+// RateLimiter is a token bucket rate limiter, holding a bucket per key
+// that refills at Rate tokens per second up to Burst tokens.  Each
+// request sent by the Client takes a token, waiting for one if the
+// bucket is empty.  Key maps a request to its bucket, by default
+// LimitByHost.
+//
+// The limiter adapts to the RateLimit-Remaining and RateLimit-Reset
+// headers, or their X-RateLimit- counterparts, in responses: until
+// the reset time, no more than the remaining number of requests are
+// sent, spread evenly over the time left.  Now may be replaced to
+// control the clock.
+type RateLimiter struct {
+	Rate  float64
+	Burst int
+	Key   func(req *http.Request) string
+	Now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket is the token bucket for one key.  The server may impose a
+// lower rate until a reset time.
+type bucket struct {
+	tokens float64
+	last   time.Time
+
+	serverRate  float64
+	serverUntil time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing rate requests per
+// second for each host, with bursts of up to burst requests.  A rate
+// of zero imposes no limit other than those the server reports.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{Rate: rate, Burst: burst}
+}
+
+// WithRateLimiter sets the rate limiter consulted before each request
+// is sent.
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(hr *Client) {
+		hr.Limiter = limiter
+	}
+}
+
+func (l *RateLimiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+func (l *RateLimiter) key(req *http.Request) string {
+	if l.Key != nil {
+		return l.Key(req)
+	}
+	return LimitByHost(req)
+}
+
+func (l *RateLimiter) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return 1
+}
+
+// bucket returns the bucket for key, refilled as of now.  It must be
+// called with l.mu held.
+func (l *RateLimiter) bucket(key string, now time.Time) *bucket {
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst(), last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	if !b.serverUntil.IsZero() && !now.Before(b.serverUntil) {
+		// the server window has reset, refilling the bucket
+		b.refill(b.serverUntil, b.serverRate, l.burst())
+		b.tokens = math.Min(b.tokens+l.burst(), l.burst())
+		b.serverRate, b.serverUntil = 0, time.Time{}
+	}
+
+	b.refill(now, l.rate(b, now), l.burst())
+
+	return b
+}
+
+// refill adds the tokens accrued at rate up to now
+func (b *bucket) refill(now time.Time, rate float64, burst float64) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		if rate > 0 {
+			b.tokens = math.Min(b.tokens+elapsed.Seconds()*rate, burst)
+		}
+		b.last = now
+	}
+}
+
+// rate returns the rate at which b refills: the lower of Rate and any
+// rate the server has imposed.  Zero means no limit, unless within a
+// server window.
+func (l *RateLimiter) rate(b *bucket, now time.Time) float64 {
+	if now.Before(b.serverUntil) && (l.Rate <= 0 || b.serverRate < l.Rate) {
+		return b.serverRate
+	}
+	return l.Rate
+}
+
+// reserve takes a token for key, returning how long the caller must
+// wait before it may be used, and a function that returns the token.
+func (l *RateLimiter) reserve(key string, now time.Time) (delay time.Duration, undo func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, now)
+
+	window := now.Before(b.serverUntil)
+	rate := l.rate(b, now)
+	if rate <= 0 && !window {
+		return 0, func() {}
+	}
+
+	b.tokens--
+	undo = func() {
+		l.mu.Lock()
+		b.tokens++
+		l.mu.Unlock()
+	}
+
+	if b.tokens >= 0 {
+		return 0, undo
+	}
+
+	owed := -b.tokens
+
+	if rate <= 0 {
+		// no requests remain until the server window resets
+		delay = b.serverUntil.Sub(now)
+		if after := owed - l.burst(); after > 0 && l.Rate > 0 {
+			delay += time.Duration(after / l.Rate * float64(time.Second))
+		}
+		return delay, undo
+	}
+
+	return time.Duration(owed / rate * float64(time.Second)), undo
+}
+
+// wait blocks until req may be sent, or returns an error if ctx is
+// done first or the wait would pass the ctx deadline.
+func (l *RateLimiter) wait(ctx context.Context, req *http.Request) (err error) {
+	key := l.key(req)
+	now := l.now()
+
+	delay, undo := l.reserve(key, now)
+	if delay <= 0 {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+		undo()
+		return &RateLimitError{Key: key, Delay: delay, Deadline: deadline}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		undo()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// observe adapts the bucket for req to the rate limit the server
+// reported in rsp, if any.
+func (l *RateLimiter) observe(req *http.Request, rsp *http.Response) {
+	now := l.now()
+
+	remaining, reset, ok := parseRateLimit(rsp.Header, now)
+	if !ok {
+		return
+	}
+
+	key := l.key(req)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, now)
+	if b.tokens > float64(remaining) {
+		b.tokens = float64(remaining)
+	}
+	if reset > 0 {
+		b.serverUntil = now.Add(reset)
+		b.serverRate = float64(remaining) / reset.Seconds()
+	}
+}
+
+// parseRateLimit returns the number of requests remaining, and the
+// time until the limit resets, from the RateLimit-Remaining and
+// RateLimit-Reset headers, or else X-RateLimit-Remaining and
+// X-RateLimit-Reset.  A reset given as a large number is taken to be
+// a Unix time, as some servers send, rather than delta-seconds.
+func parseRateLimit(header http.Header, now time.Time) (remaining int, reset time.Duration, ok bool) {
+	for _, prefix := range []string{"Ratelimit-", "X-Ratelimit-"} {
+		s := header.Get(prefix + "Remaining")
+		if s == "" {
+			continue
+		}
+
+		// RateLimit-Remaining may carry parameters, e.g., "10;w=60"
+		if i := strings.IndexAny(s, ";,"); i >= 0 {
+			s = s[:i]
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 0 {
+			continue
+		}
+
+		s = header.Get(prefix + "Reset")
+		if i := strings.IndexAny(s, ";,"); i >= 0 {
+			s = s[:i]
+		}
+		if v, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil && v > 0 {
+			if v > 1000000000 {
+				reset = time.Unix(v, 0).Sub(now)
+			} else {
+				reset = time.Duration(v) * time.Second
+			}
+			if reset < 0 {
+				reset = 0
+			}
+		}
+
+		return n, reset, true
+	}
+
+	return 0, 0, false
+}
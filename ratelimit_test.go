@@ -0,0 +1,179 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+
+	l := NewRateLimiter(2, 2)
+	l.Now = func() time.Time { return now }
+
+	tests := []struct {
+		Advance time.Duration
+		Delay   time.Duration
+	}{
+		// the burst is available at once
+		{0, 0},
+		{0, 0},
+		// then a token every 500ms, queued waiters further out
+		{0, 500 * time.Millisecond},
+		{0, time.Second},
+		{time.Second, 500 * time.Millisecond},
+		{2 * time.Second, 0},
+	}
+
+	for i, v := range tests {
+		now = now.Add(v.Advance)
+		delay, _ := l.reserve("example.com", now)
+		if delay != v.Delay {
+			t.Errorf("%d: expected delay %s, got %s", i, v.Delay, delay)
+		}
+	}
+
+	if delay, _ := l.reserve("other.example.com", now); delay != 0 {
+		t.Errorf("expected a separate bucket for another key, got delay %s", delay)
+	}
+}
+
+func TestRateLimiterAdapt(t *testing.T) {
+	now := time.Now()
+
+	l := NewRateLimiter(0, 5)
+	l.Now = func() time.Time { return now }
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+
+	// without a limit of its own, the limiter does not delay
+	for i := 0; i < 10; i++ {
+		if delay, _ := l.reserve(l.key(req), now); delay != 0 {
+			t.Fatalf("%d: expected no delay, got %s", i, delay)
+		}
+	}
+
+	// the server allows no more requests for 30 seconds
+	rsp := &http.Response{Header: http.Header{}}
+	rsp.Header.Set("RateLimit-Remaining", "0")
+	rsp.Header.Set("RateLimit-Reset", "30")
+	l.observe(req, rsp)
+
+	if delay, _ := l.reserve(l.key(req), now); delay != 30*time.Second {
+		t.Errorf("expected to wait for the reset, got %s", delay)
+	}
+
+	// after the reset, the burst is available again
+	now = now.Add(31 * time.Second)
+	if delay, _ := l.reserve(l.key(req), now); delay != 0 {
+		t.Errorf("expected no delay after the reset, got %s", delay)
+	}
+
+	// a server allowing 10 requests in the next 20 seconds paces
+	// them 2 seconds apart, once the 4 tokens left in the bucket
+	// are spent
+	rsp.Header = http.Header{}
+	rsp.Header.Set("X-RateLimit-Remaining", "10")
+	rsp.Header.Set("X-RateLimit-Reset", "20")
+	l.observe(req, rsp)
+
+	for i, expected := range []time.Duration{0, 0, 0, 0, 2 * time.Second, 4 * time.Second} {
+		if delay, _ := l.reserve(l.key(req), now); delay != expected {
+			t.Errorf("%d: expected the server rate to delay %s, got %s", i, expected, delay)
+		}
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		Header    map[string]string
+		Remaining int
+		Reset     time.Duration
+		Ok        bool
+	}{
+		{map[string]string{"RateLimit-Remaining": "10", "RateLimit-Reset": "60"}, 10, time.Minute, true},
+		{map[string]string{"RateLimit-Remaining": "10;w=60"}, 10, 0, true},
+		{map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "1700000030"}, 0, 30 * time.Second, true},
+		{map[string]string{"X-RateLimit-Remaining": "lots"}, 0, 0, false},
+		{map[string]string{}, 0, 0, false},
+	}
+
+	for i, v := range tests {
+		header := http.Header{}
+		for k, s := range v.Header {
+			header.Set(k, s)
+		}
+		remaining, reset, ok := parseRateLimit(header, now)
+		if remaining != v.Remaining || reset != v.Reset || ok != v.Ok {
+			t.Errorf("%d: expected %d %s %v, got %d %s %v", i, v.Remaining, v.Reset, v.Ok, remaining, reset, ok)
+		}
+	}
+}
+
+func TestLimitByCredential(t *testing.T) {
+	credentials := &OrderedCredentials{[]Credential{NewCredential("api.example.com", "", "partner", "secret")}}
+	key := LimitByCredential(credentials)
+
+	for i, v := range []struct {
+		URL string
+		Key string
+	}{
+		{"https://api.example.com/v1", "credential:partner"},
+		{"https://www.example.com/", "www.example.com"},
+	} {
+		uri, _ := url.Parse(v.URL)
+		if k := key(&http.Request{URL: uri}); k != v.Key {
+			t.Errorf("%d: expected key %s, got %s", i, v.Key, k)
+		}
+	}
+}
+
+func TestDoRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	client := New(
+		WithRateLimiter(NewRateLimiter(5, 1)),
+		WithTimeouts(Timeouts{Total: time.Second}),
+	)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		rsp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		rsp.Body.Close()
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("expected 3 requests at 5/s to take at least 400ms, took %s", elapsed)
+	}
+
+	// a wait past the deadline fails fast
+	client.Limiter = NewRateLimiter(0.1, 1)
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		rsp, err := client.DoContext(ctx, req)
+		cancel()
+		if i == 0 {
+			if err != nil {
+				t.Fatal(err)
+			}
+			rsp.Body.Close()
+			continue
+		}
+		var limited *RateLimitError
+		if !errors.As(err, &limited) {
+			t.Errorf("expected a RateLimitError, got %v", err)
+		}
+	}
+}
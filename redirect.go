@@ -0,0 +1,173 @@
+package httpclient
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultMaxRedirects is the number of redirects DoAuth follows
+// when the RedirectPolicy does not set MaxHops.
+const DefaultMaxRedirects = 10
+
+// RedirectPolicy configures how DoAuth follows redirects.
+type RedirectPolicy struct {
+	// MaxHops limits the number of redirects followed, after which
+	// DoAuth fails.  Zero selects DefaultMaxRedirects, while a
+	// negative value returns the first redirect response as is.
+	MaxHops int
+
+	// SameOrigin reports whether a redirect from one URL to
+	// another stays within the same origin, so that Authorization
+	// and Cookie headers set by the caller may be carried to it.
+	// By default URLs share an origin if their scheme, host and
+	// port match.
+	SameOrigin func(from, to *url.URL) bool
+}
+
+// WithRedirectPolicy sets the policy DoAuth uses to follow redirects
+func WithRedirectPolicy(policy *RedirectPolicy) Option {
+	return func(hr *Client) {
+		hr.RedirectPolicy = policy
+	}
+}
+
+// sameOrigin reports whether a and b share a scheme, host and port
+func sameOrigin(a, b *url.URL) bool {
+	port := func(u *url.URL) string {
+		if p := u.Port(); p != "" {
+			return p
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "http":
+			return "80"
+		case "https":
+			return "443"
+		}
+		return ""
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		strings.EqualFold(a.Hostname(), b.Hostname()) &&
+		port(a) == port(b)
+}
+
+// followRedirects sends req via auth, following each redirect it
+// is sent as the RedirectPolicy allows.  body holds a copy of the
+// original request body, to be resent on a 307 or 308 redirect.
+func (hr *Client) followRedirects(req *http.Request, body *replay, auth *AuthTransport) (rsp *http.Response, err error) {
+	policy := hr.RedirectPolicy
+	if policy == nil {
+		policy = &RedirectPolicy{}
+	}
+
+	max := policy.MaxHops
+	if max == 0 {
+		max = DefaultMaxRedirects
+	}
+
+	same := policy.SameOrigin
+	if same == nil {
+		same = sameOrigin
+	}
+
+	var via []*http.Request
+
+	for {
+		rsp, err = auth.RoundTrip(req)
+		if err != nil || max < 0 {
+			return
+		}
+
+		var next *http.Request
+		next, err = redirect(req, rsp, body, same)
+		if err != nil {
+			rsp.Body.Close()
+			return nil, err
+		}
+		if next == nil {
+			return
+		}
+
+		if len(via) >= max {
+			rsp.Body.Close()
+			return nil, fmt.Errorf("error requesting %s: stopped after %d redirects", via[0].URL, max)
+		}
+
+		via = append(via, req)
+
+		if hr.CheckRedirect != nil {
+			err = hr.CheckRedirect(next, via)
+			if err == http.ErrUseLastResponse {
+				return rsp, nil
+			} else if err != nil {
+				rsp.Body.Close()
+				return nil, err
+			}
+		}
+
+		// drain the body so that the connection may be reused
+		io.Copy(ioutil.Discard, io.LimitReader(rsp.Body, 2<<10))
+		rsp.Body.Close()
+
+		req = next
+	}
+}
+
+// redirect returns the request to send in response to the redirect
+// rsp to req, or nil if rsp is not a redirect to be followed.
+func redirect(req *http.Request, rsp *http.Response, body *replay, same func(from, to *url.URL) bool) (next *http.Request, err error) {
+	method := req.Method
+	keepBody := false
+
+	switch rsp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther:
+		if method != "GET" && method != "HEAD" {
+			method = "GET"
+		}
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		keepBody = true
+	default:
+		return nil, nil
+	}
+
+	location := rsp.Header.Get("Location")
+	if location == "" {
+		return nil, nil
+	}
+
+	uri, err := req.URL.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s redirect Location %s: %v", req.URL, location, err)
+	}
+
+	next, err = http.NewRequestWithContext(req.Context(), method, uri.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range req.Header {
+		next.Header[k] = append([]string(nil), v...)
+	}
+
+	if !same(req.URL, uri) {
+		for _, k := range []string{"Authorization", "Cookie", "Cookie2"} {
+			next.Header.Del(k)
+		}
+	}
+
+	if keepBody {
+		next.ContentLength = req.ContentLength
+		err = body.rewind(next)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		next.Header.Del("Content-Type")
+		next.Header.Del("Content-Length")
+	}
+
+	return next, nil
+}
@@ -0,0 +1,221 @@
+package httpclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// redirectTestHandler records the requests it receives, and serves a
+// redirect for each path in redirects.
+type redirectTestHandler struct {
+	sync.Mutex
+	redirects map[string]redirectTo
+	requests  []redirectTestRequest
+	next      http.Handler
+}
+
+type redirectTo struct {
+	status   int
+	location string
+}
+
+type redirectTestRequest struct {
+	method string
+	path   string
+	auth   string
+	body   string
+}
+
+func (h *redirectTestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	b, _ := ioutil.ReadAll(req.Body)
+
+	h.Lock()
+	h.requests = append(h.requests, redirectTestRequest{req.Method, req.URL.Path, req.Header.Get("Authorization"), string(b)})
+	to, ok := h.redirects[req.URL.Path]
+	h.Unlock()
+
+	if ok {
+		w.Header().Set("Location", to.location)
+		w.WriteHeader(to.status)
+		return
+	}
+	if h.next != nil {
+		h.next.ServeHTTP(w, req)
+	}
+}
+
+func TestDoAuthRedirect(t *testing.T) {
+	// the target requires Basic authentication
+	target := &redirectTestHandler{
+		next: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if username, password, ok := req.BasicAuth(); !ok || username != "other" || password != "secret" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="target"`)
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}),
+	}
+	targetServer := httptest.NewServer(target)
+	defer targetServer.Close()
+
+	// the origin requires Digest authentication, and redirects to
+	// itself and then to the target
+	digest := newDigestTestHandler("nonce-1")
+	origin := &redirectTestHandler{
+		redirects: map[string]redirectTo{
+			"/post":  {http.StatusTemporaryRedirect, "/moved"},
+			"/moved": {http.StatusFound, targetServer.URL + "/target"},
+		},
+	}
+	origin.next = digest
+	originServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the origin challenges everything but the final hop
+		if _, ok := digest.nonces[parseDigestTestParams(req.Header.Get("Authorization"))["nonce"]]; !ok {
+			digest.ServeHTTP(w, req)
+			return
+		}
+		origin.ServeHTTP(w, req)
+	}))
+	defer originServer.Close()
+
+	originURL, _ := url.Parse(originServer.URL)
+	targetURL, _ := url.Parse(targetServer.URL)
+
+	credentials := &OrderedCredentials{[]Credential{
+		NewCredential(originURL.Host, "", digest.username, digest.password),
+		NewCredential(targetURL.Host, "", "other", "secret"),
+	}}
+	session := NewSession(credentials, 1000, "", -1)
+
+	client := NewClient(5 * time.Second)
+
+	req, err := http.NewRequest("POST", originServer.URL+"/post", ioutil.NopCloser(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Cookie", "origin=1")
+
+	rsp, err := client.DoAuth(req, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rsp.StatusCode)
+	}
+
+	origin.Lock()
+	defer origin.Unlock()
+
+	// the 307 to /moved keeps the method and body, and is
+	// authorized preemptively from the cache
+	if len(origin.requests) != 2 {
+		t.Fatalf("expected 2 authorized requests to the origin, got %+v", origin.requests)
+	}
+	for i, path := range []string{"/post", "/moved"} {
+		v := origin.requests[i]
+		if v.method != "POST" || v.path != path || v.body != "payload" || !strings.HasPrefix(v.auth, "Digest ") {
+			t.Errorf("%d: unexpected request to the origin %+v", i, v)
+		}
+	}
+
+	// the 302 to the target becomes a GET, carries neither the
+	// origin Authorization nor Cookie, and answers the target's
+	// own challenge
+	target.Lock()
+	defer target.Unlock()
+	if len(target.requests) != 2 {
+		t.Fatalf("expected 2 requests to the target, got %+v", target.requests)
+	}
+	if v := target.requests[0]; v.method != "GET" || v.auth != "" || v.body != "" {
+		t.Errorf("unexpected first request to the target %+v", v)
+	}
+	if v := target.requests[1]; !strings.HasPrefix(v.auth, "Basic ") {
+		t.Errorf("expected the target's challenge to be answered, got %+v", v)
+	}
+}
+
+func TestDoAuthRedirectPolicy(t *testing.T) {
+	handler := &redirectTestHandler{
+		redirects: map[string]redirectTo{
+			"/loop": {http.StatusFound, "/loop"},
+			"/a":    {http.StatusMovedPermanently, "/b"},
+		},
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	session := NewSession(&OrderedCredentials{}, 1000, "", -1)
+
+	tests := []struct {
+		Path   string
+		Policy *RedirectPolicy
+		Status int
+		Err    string
+	}{
+		{"/loop", &RedirectPolicy{MaxHops: 3}, 0, "stopped after 3 redirects"},
+		{"/a", &RedirectPolicy{MaxHops: -1}, http.StatusMovedPermanently, ""},
+		{"/a", nil, http.StatusOK, ""},
+	}
+
+	for i, v := range tests {
+		client := New(WithRedirectPolicy(v.Policy))
+
+		req, err := http.NewRequest("GET", server.URL+v.Path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer caller")
+
+		rsp, err := client.DoAuth(req, session)
+		if v.Err != "" {
+			if err == nil || !strings.Contains(err.Error(), v.Err) {
+				t.Errorf("%d: expected an error containing %q, got %v", i, v.Err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		rsp.Body.Close()
+
+		if rsp.StatusCode != v.Status {
+			t.Errorf("%d: expected status %d, got %d", i, v.Status, rsp.StatusCode)
+		}
+	}
+
+	// same origin hops keep the caller's Authorization
+	handler.Lock()
+	defer handler.Unlock()
+	last := handler.requests[len(handler.requests)-1]
+	if last.path != "/b" || last.auth != "Bearer caller" {
+		t.Errorf("expected a same origin hop to keep Authorization, got %+v", last)
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		A, B string
+		Same bool
+	}{
+		{"http://example.com/a", "http://EXAMPLE.com:80/b", true},
+		{"https://example.com/", "https://example.com:443/", true},
+		{"http://example.com/", "https://example.com/", false},
+		{"http://example.com/", "http://www.example.com/", false},
+		{"http://example.com:8080/", "http://example.com/", false},
+	}
+
+	for i, v := range tests {
+		a, _ := url.Parse(v.A)
+		b, _ := url.Parse(v.B)
+		if same := sameOrigin(a, b); same != v.Same {
+			t.Errorf("%d: expected %v, got %v", i, v.Same, same)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultRetryAttempts  = 3
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+	DefaultRetryMaxDelay  = 10 * time.Second
+	DefaultRetryAfterMax  = time.Minute
+)
+
+// DefaultRetryStatus lists the response status codes retried when a
+// RetryPolicy does not specify its own.
+var DefaultRetryStatus = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy configures how Client.Do retries a request that fails
+// with a connection error or timeout, or whose response status is one
+// of Status.  Attempts are spaced using exponential backoff with full
+// jitter: before attempt n+1 the Client waits a random duration of up
+// to BaseDelay * 2^(n-1), capped at MaxDelay.  Retries stop after
+// MaxAttempts attempts, when the next attempt would begin more than
+// MaxElapsed after the first, or when the request context is done.
+//
+// If a 429 or 503 response carries a Retry-After header, the Client
+// waits the delay it specifies instead, up to MaxRetryAfter.  If the
+// delay would pass the request context deadline, Do fails at once
+// with a *RetryAfterError.  OnRetry, if set, is called with the delay
+// chosen before each wait.
+//
+// A request refused by an open circuit breaker or a full bulkhead,
+// or that cannot be sent within its deadline under the rate limit,
+// is not retried.  Only
+// idempotent requests are retried, unless NonIdempotent is set.
+// A request body is replayed using the Session of the call, the one
+// passed to DoAuth or else the Client Session, or failing that the
+// request GetBody function; without either, a request with a body is
+// not retried.  Zero values select the defaults.
+type RetryPolicy struct {
+	MaxAttempts   int
+	MaxElapsed    time.Duration
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	MaxRetryAfter time.Duration
+	Status        []int
+	NonIdempotent bool
+	OnRetry       func(req *http.Request, attempt int, delay time.Duration)
+
+	// now and jitter may be replaced in tests; jitter returns a
+	// random duration in [0, d).
+	now    func() time.Time
+	jitter func(d time.Duration) time.Duration
+}
+
+// WithRetry sets the policy used to retry failed requests.
+func WithRetry(policy *RetryPolicy) Option {
+	return func(hr *Client) {
+		hr.Retry = policy
+	}
+}
+
+// idempotent reports whether req may be retried without opting in
+func (p *RetryPolicy) idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return p.NonIdempotent
+}
+
+// retryable reports whether the outcome of an attempt warrants
+// another.
+func (p *RetryPolicy) retryable(rsp *http.Response, err error) bool {
+	if err != nil {
+		return retryableError(err)
+	}
+	status := p.Status
+	if status == nil {
+		status = DefaultRetryStatus
+	}
+	for _, v := range status {
+		if rsp.StatusCode == v {
+			return true
+		}
+	}
+	return false
+}
+
+// retryableError reports whether err is a timeout or a failure of the
+// connection, which another attempt may avoid.  Errors that would
+// recur, e.g., a certificate that fails verification, an unsupported
+// URL scheme, or a failure to sign or authorize the request, are not
+// retried.
+func retryableError(err error) bool {
+	var limited *RateLimitError
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrBulkheadFull) || errors.As(err, &limited) {
+		return false
+	}
+
+	if isTimeout(err) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	// a TLS alert from the server is reported as a "remote error"
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial" || opErr.Op == "read" || opErr.Op == "write"
+	}
+
+	return false
+}
+
+// backoff returns the delay to wait after the specified attempt
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+	if max <= 0 {
+		max = DefaultRetryMaxDelay
+	}
+
+	d := max
+	if attempt < 32 && base<<uint(attempt-1) < max && base<<uint(attempt-1) > 0 {
+		d = base << uint(attempt-1)
+	}
+
+	if p.jitter != nil {
+		return p.jitter(d)
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func (p *RetryPolicy) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// do sends req via send, retrying as the policy allows.  The response
+// to each abandoned attempt is drained and closed.
+func (p *RetryPolicy) do(ctx context.Context, req *http.Request, session Session, send func(context.Context, *http.Request) (*http.Response, error)) (rsp *http.Response, err error) {
+	if !p.idempotent(req) {
+		return send(ctx, req)
+	}
+
+	var body *replay
+	if req.Body != nil && req.Body != http.NoBody {
+		if session != nil {
+			body, err = newReplay(session, req)
+			if err != nil {
+				return
+			}
+			defer body.Close()
+		} else if req.GetBody == nil {
+			return send(ctx, req)
+		}
+	}
+
+	max := p.MaxAttempts
+	if max <= 0 {
+		max = DefaultRetryAttempts
+	}
+
+	start := p.clock()
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			rsp, err = nil, nil
+			if body != nil {
+				err = body.rewind(req)
+			} else if req.GetBody != nil {
+				req.Body, err = req.GetBody()
+			}
+			if err != nil {
+				return
+			}
+		}
+
+		rsp, err = send(ctx, req)
+
+		if attempt >= max || ctx.Err() != nil || !p.retryable(rsp, err) {
+			return
+		}
+
+		delay, ok := p.retryAfter(rsp)
+		if ok {
+			if deadline, set := ctx.Deadline(); set && p.clock().Add(delay).After(deadline) {
+				io.Copy(ioutil.Discard, rsp.Body)
+				rsp.Body.Close()
+				return nil, &RetryAfterError{
+					URL:      req.URL.String(),
+					Status:   rsp.StatusCode,
+					Delay:    delay,
+					Deadline: deadline,
+				}
+			}
+		} else {
+			delay = p.backoff(attempt)
+		}
+
+		if !p.wait(ctx, start, delay) {
+			return
+		}
+
+		if p.OnRetry != nil {
+			p.OnRetry(req, attempt, delay)
+		}
+		hooksFrom(ctx).retry(req, attempt, delay)
+
+		if rsp != nil {
+			io.Copy(ioutil.Discard, rsp.Body)
+			rsp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// wait reports whether there is time to wait delay and try again,
+// within both the MaxElapsed budget and the context deadline.
+func (p *RetryPolicy) wait(ctx context.Context, start time.Time, delay time.Duration) bool {
+	now := p.clock()
+	if p.MaxElapsed > 0 && now.Add(delay).Sub(start) > p.MaxElapsed {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+		return false
+	}
+	return true
+}
+
+// retryAfter returns the delay requested by the Retry-After header of
+// a 429 or 503 response, bounded by MaxRetryAfter.
+func (p *RetryPolicy) retryAfter(rsp *http.Response) (delay time.Duration, ok bool) {
+	if rsp == nil || (rsp.StatusCode != http.StatusTooManyRequests && rsp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+
+	delay, ok = parseRetryAfter(rsp.Header.Get("Retry-After"), p.clock())
+	if !ok {
+		return 0, false
+	}
+
+	max := p.MaxRetryAfter
+	if max <= 0 {
+		max = DefaultRetryAfterMax
+	}
+	if delay > max {
+		delay = max
+	}
+
+	return delay, true
+}
+
+// parseRetryAfter parses a Retry-After header value, given as either
+// delta-seconds or an HTTP-date (RFC 7231 7.1.3), returning the delay
+// it specifies relative to now.
+func parseRetryAfter(s string, now time.Time) (delay time.Duration, ok bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		if seconds > int64(math.MaxInt64/time.Second) {
+			seconds = int64(math.MaxInt64 / time.Second)
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	t, err := http.ParseTime(s)
+	if err != nil {
+		return 0, false
+	}
+
+	delay = t.Sub(now)
+	if delay < 0 {
+		delay = 0
+	}
+
+	return delay, true
+}
+
+// RetryAfterError is returned when a server asks, via Retry-After,
+// that a request be retried at a time past the request deadline.
+type RetryAfterError struct {
+	URL      string
+	Status   int
+	Delay    time.Duration
+	Deadline time.Time
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("error requesting %s: status %d asked to retry after %s, past the deadline at %s",
+		e.URL, e.Status, e.Delay, e.Deadline.Format(time.RFC3339))
+}
@@ -0,0 +1,406 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyTestHandler fails the first failures requests, either with
+// status or, if status is zero, by dropping the connection.  It
+// records the body of each request.
+type flakyTestHandler struct {
+	sync.Mutex
+	failures int
+	status   int
+	bodies   []string
+}
+
+func (h *flakyTestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	b, _ := ioutil.ReadAll(req.Body)
+
+	h.Lock()
+	h.bodies = append(h.bodies, string(b))
+	n := len(h.bodies)
+	h.Unlock()
+
+	if n <= h.failures {
+		if h.status == 0 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.WriteHeader(h.status)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		Method   string
+		Failures int
+		Status   int
+		Policy   RetryPolicy
+		Session  bool
+		Attempts int
+		Result   int
+	}{
+		// a 503 is retried until it succeeds
+		{"GET", 2, http.StatusServiceUnavailable, RetryPolicy{}, false, 3, http.StatusOK},
+		// a dropped connection is retried
+		{"GET", 1, 0, RetryPolicy{}, false, 2, http.StatusOK},
+		// the last response is returned when attempts run out
+		{"GET", 5, http.StatusBadGateway, RetryPolicy{MaxAttempts: 2}, false, 2, http.StatusBadGateway},
+		// a status not listed is not retried
+		{"GET", 1, http.StatusInternalServerError, RetryPolicy{}, false, 1, http.StatusInternalServerError},
+		// a non-idempotent request is not retried by default
+		{"POST", 1, http.StatusServiceUnavailable, RetryPolicy{}, false, 1, http.StatusServiceUnavailable},
+		// unless the caller opts in, and the body is replayed
+		{"POST", 2, http.StatusServiceUnavailable, RetryPolicy{NonIdempotent: true}, true, 3, http.StatusOK},
+		{"PUT", 1, http.StatusTooManyRequests, RetryPolicy{}, true, 2, http.StatusOK},
+		// the elapsed budget ends retries
+		{"GET", 5, http.StatusServiceUnavailable, RetryPolicy{MaxAttempts: 10, MaxElapsed: 150 * time.Millisecond}, false, 2, http.StatusServiceUnavailable},
+	}
+
+	for i, v := range tests {
+		handler := &flakyTestHandler{failures: v.Failures, status: v.Status}
+		server := httptest.NewServer(handler)
+
+		policy := v.Policy
+		policy.jitter = func(d time.Duration) time.Duration { return 100 * time.Millisecond }
+
+		client := New(WithRetry(&policy), WithTimeouts(Timeouts{Total: 5 * time.Second}))
+		if v.Session {
+			// a limit of 4 bytes spills the body to a temporary file
+			client.Session = NewSession(&OrderedCredentials{}, 1000, "", 4)
+		}
+
+		var body *strings.Reader
+		if v.Method != "GET" {
+			body = strings.NewReader("request body")
+		}
+
+		var req *http.Request
+		var err error
+		if body != nil {
+			// hide the body type, so that http.NewRequest does not set GetBody
+			req, err = http.NewRequest(v.Method, server.URL, ioutil.NopCloser(body))
+		} else {
+			req, err = http.NewRequest(v.Method, server.URL, nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+		} else {
+			rsp.Body.Close()
+			if rsp.StatusCode != v.Result {
+				t.Errorf("%d: expected status %d, got %d", i, v.Result, rsp.StatusCode)
+			}
+		}
+
+		handler.Lock()
+		if len(handler.bodies) != v.Attempts {
+			t.Errorf("%d: expected %d attempts, got %d", i, v.Attempts, len(handler.bodies))
+		}
+		for j, b := range handler.bodies {
+			if body != nil && b != "request body" {
+				t.Errorf("%d: attempt %d expected the request body, got %q", i, j+1, b)
+			}
+		}
+		handler.Unlock()
+
+		server.Close()
+	}
+}
+
+// TestRetryDoAuthSession checks a body sent via DoAuth is replayed
+// using the Session passed to DoAuth, when the Client has none.
+func TestRetryDoAuthSession(t *testing.T) {
+	handler := &flakyTestHandler{failures: 1, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	policy := &RetryPolicy{jitter: func(d time.Duration) time.Duration { return time.Millisecond }}
+	client := New(WithRetry(policy))
+	session := NewSession(&OrderedCredentials{}, 1000, "", -1)
+
+	// hide the body type, so that http.NewRequest does not set GetBody
+	req, err := http.NewRequest("PUT", server.URL, ioutil.NopCloser(strings.NewReader("request body")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := client.DoAuth(req, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rsp.StatusCode)
+	}
+
+	handler.Lock()
+	defer handler.Unlock()
+	expected := []string{"request body", "request body"}
+	if strings.Join(handler.bodies, "; ") != strings.Join(expected, "; ") {
+		t.Errorf("expected bodies %q, got %q", expected, handler.bodies)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay:  time.Second,
+		jitter:    func(d time.Duration) time.Duration { return d },
+	}
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+
+	for i, v := range expected {
+		if d := p.backoff(i + 1); d != v {
+			t.Errorf("%d: expected %s, got %s", i, v, d)
+		}
+	}
+
+	if d := p.backoff(100); d != time.Second {
+		t.Errorf("expected a large attempt to be capped at %s, got %s", time.Second, d)
+	}
+
+	p.jitter = nil
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(3); d < 0 || d >= 400*time.Millisecond {
+			t.Fatalf("expected full jitter within [0, 400ms), got %s", d)
+		}
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	handler := &flakyTestHandler{failures: 5, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	policy := &RetryPolicy{
+		MaxAttempts: 5,
+		jitter:      func(d time.Duration) time.Duration { return time.Second },
+	}
+
+	// the backoff would outlast the timeout, so the first
+	// response is returned without waiting
+	client := New(WithRetry(policy), WithTimeouts(Timeouts{Total: 500 * time.Millisecond}))
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	rsp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", rsp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("expected to fail fast, took %s", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+
+	tests := []struct {
+		Value string
+		Delay time.Duration
+		Ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"Wed, 21 Oct 2015 07:30:00 GMT", 2 * time.Minute, true},
+		{"Wednesday, 21-Oct-15 07:28:30 GMT", 30 * time.Second, true},
+		{"Wed, 21 Oct 2015 07:00:00 GMT", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+
+	for i, v := range tests {
+		delay, ok := parseRetryAfter(v.Value, now)
+		if ok != v.Ok || delay != v.Delay {
+			t.Errorf("%d: expected %s %v, got %s %v", i, v.Delay, v.Ok, delay, ok)
+		}
+	}
+}
+
+// retryAfterTestHandler answers the first request with status and a
+// Retry-After header, and later requests with 200 OK.
+type retryAfterTestHandler struct {
+	sync.Mutex
+	status     int
+	retryAfter string
+	count      int
+}
+
+func (h *retryAfterTestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.Lock()
+	h.count++
+	n := h.count
+	h.Unlock()
+
+	if n == 1 {
+		w.Header().Set("Retry-After", h.retryAfter)
+		w.WriteHeader(h.status)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		Status     int
+		RetryAfter string
+		Max        time.Duration
+		Timeout    time.Duration
+		Delay      time.Duration
+		Err        bool
+	}{
+		// the server's delay replaces the backoff
+		{http.StatusTooManyRequests, "1", 0, 5 * time.Second, time.Second, false},
+		// bounded by MaxRetryAfter
+		{http.StatusServiceUnavailable, "3600", 200 * time.Millisecond, 5 * time.Second, 200 * time.Millisecond, false},
+		// a delay past the deadline fails fast
+		{http.StatusServiceUnavailable, "10", 0, time.Second, 0, true},
+		// only 429 and 503 carry a meaningful Retry-After
+		{http.StatusBadGateway, "10", 0, 5 * time.Second, 100 * time.Millisecond, false},
+	}
+
+	for i, v := range tests {
+		handler := &retryAfterTestHandler{status: v.Status, retryAfter: v.RetryAfter}
+		server := httptest.NewServer(handler)
+
+		var observed []time.Duration
+		policy := &RetryPolicy{
+			MaxRetryAfter: v.Max,
+			OnRetry: func(req *http.Request, attempt int, delay time.Duration) {
+				observed = append(observed, delay)
+			},
+			jitter: func(d time.Duration) time.Duration { return d },
+		}
+
+		client := New(WithRetry(policy), WithTimeouts(Timeouts{Total: v.Timeout}))
+
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		rsp, err := client.Do(req)
+		elapsed := time.Since(start)
+
+		if v.Err {
+			if _, ok := err.(*RetryAfterError); !ok {
+				t.Errorf("%d: expected a RetryAfterError, got %v", i, err)
+			}
+			if elapsed > v.Timeout/2 {
+				t.Errorf("%d: expected to fail fast, took %s", i, elapsed)
+			}
+		} else if err != nil {
+			t.Errorf("%d: %v", i, err)
+		} else {
+			rsp.Body.Close()
+			if rsp.StatusCode != http.StatusOK {
+				t.Errorf("%d: expected status 200, got %d", i, rsp.StatusCode)
+			}
+			if len(observed) != 1 || observed[0] != v.Delay {
+				t.Errorf("%d: expected to observe a delay of %s, got %v", i, v.Delay, observed)
+			}
+			if elapsed < v.Delay {
+				t.Errorf("%d: expected to wait %s, took %s", i, v.Delay, elapsed)
+			}
+		}
+
+		server.Close()
+	}
+}
+
+func TestRetryableError(t *testing.T) {
+	tests := []struct {
+		Err       error
+		Retryable bool
+	}{
+		{&url.Error{Op: "Get", URL: "http://example.com/", Err: io.EOF}, true},
+		{&url.Error{Op: "Get", URL: "http://example.com/", Err: io.ErrUnexpectedEOF}, true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{&TimeoutError{Phase: PhaseHeaders, Err: context.DeadlineExceeded}, true},
+		{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{&url.Error{Op: "Get", URL: "https://example.com/", Err: x509.UnknownAuthorityError{}}, false},
+		{&url.Error{Op: "Get", URL: "https://example.com/", Err: &net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}}, false},
+		{&url.Error{Op: "Get", URL: "ftp://example.com/", Err: errors.New(`unsupported protocol scheme "ftp"`)}, false},
+		{errors.New("unable to sign request"), false},
+		{&MutualAuthError{URL: "http://example.com/", Reason: "rspauth mismatch"}, false},
+		{&CircuitOpenError{Host: "example.com"}, false},
+	}
+
+	for i, v := range tests {
+		if retryable := retryableError(v.Err); retryable != v.Retryable {
+			t.Errorf("%d: expected %v for %v, got %v", i, v.Retryable, v.Err, retryable)
+		}
+	}
+}
+
+// TestRetryTLS checks that a certificate that fails verification is
+// not retried.
+func TestRetryTLS(t *testing.T) {
+	var attempts int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	client := New(WithRetry(&RetryPolicy{
+		OnRetry: func(req *http.Request, attempt int, delay time.Duration) { attempts++ },
+	}))
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Do(req)
+	if err == nil {
+		t.Fatal("expected a certificate verification error")
+	}
+	if attempts != 0 {
+		t.Errorf("expected no retries, got %d", attempts)
+	}
+}
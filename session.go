@@ -1,20 +1,41 @@
 package httpclient
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Session holds the credentials and the authentication state shared
+// by the requests of a Client.
+//
+// The interface has changed incompatibly since its first release: the
+// authorization cache now holds the Challenge answered along with the
+// header value, the Digest caches are keyed by algorithm and realm and
+// bound to the server nonce, and Token, SetProxyAuthorization and
+// ProxyAuthorization have been added.  A Session implemented outside
+// this package must be updated to match; embedding the Session
+// returned by NewSession and overriding only the methods of interest
+// keeps it working as the interface grows.
 type Session interface {
 	// Login returns a username and password for a specified uri
 	// and relam, or an error.  If no authentication credentials
 	// could be found, NoCredentialsErr should be returned.
 	Login(uri *url.URL, realm string) (username, password string, err error)
 
+	// Token returns a bearer token for the specified uri, realm
+	// and scope.  If refresh is true, the token last returned was
+	// rejected by the server and a new one should be obtained.  If
+	// no token could be found, NoCredentialsErr should be returned.
+	Token(uri *url.URL, realm string, scope []string, refresh bool) (token string, err error)
+
 	// CNonce returns a random nonce for use in Digest authentication.
 	CNonce() (cnonce string, err error)
 
@@ -23,30 +44,49 @@ type Session interface {
 	// Counter.
 	Counter(nonce string) string
 
-	// SetAuthorization caches the Authenticate header value for
-	// the specified uri and domains.
-	SetAuthorization(uri *url.URL, domain []string, auth string)
-
-	// Authorization returns the Authenticate header value cached
-	// for the specified uri
-	Authorization(uri *url.URL) (auth string)
-
-	// SetDigestCredentials caches the specified credentials hash
-	// string for the specified uri host and domains.  If domain
-	// is an empty array, then the domain "/" is assumed.
-	SetDigestCredentials(uri *url.URL, domain []string, hash string)
-
-	// DigestCredentials returns the cached credentials hash
-	// string for the specified uri host.
-	DigestCredentials(uri *url.URL) (hash string)
-
-	// SetDigestSession caches the specified session hash string
-	// for the specified server
-	SetDigestSession(server, hash string)
-
-	// DigestSession returns the cached session hash string for
+	// SetAuthorization caches the challenge and the Authorization
+	// header value sent in response to it for the specified uri and
+	// domains.
+	SetAuthorization(uri *url.URL, domain []string, challenge *Challenge, auth string)
+
+	// Authorization returns the challenge and Authorization header
+	// value cached for the specified uri.  Digest challenges should
+	// be answered anew for each request, rather than by resending
+	// auth.
+	Authorization(uri *url.URL) (challenge *Challenge, auth string)
+
+	// SetProxyAuthorization caches the challenge and the
+	// Proxy-Authorization header value sent in response to it for
+	// the specified proxy.
+	SetProxyAuthorization(proxy *url.URL, challenge *Challenge, auth string)
+
+	// ProxyAuthorization returns the challenge and
+	// Proxy-Authorization header value cached for the specified
+	// proxy.
+	ProxyAuthorization(proxy *url.URL) (challenge *Challenge, auth string)
+
+	// SetDigestCredentials caches the specified username and
+	// credentials hash string, computed with the named Digest
+	// algorithm for realm, for the specified uri host and domains.
+	// If domain is an empty array, then the domain "/" is assumed.
+	SetDigestCredentials(uri *url.URL, domain []string, realm, algorithm, username, hash string)
+
+	// DigestCredentials returns the cached username and credentials
+	// hash string computed with the named Digest algorithm for
+	// realm, from the domain of the specified uri host with the
+	// longest path matching the uri path.
+	DigestCredentials(uri *url.URL, realm, algorithm string) (username, hash string)
+
+	// SetDigestSession caches the specified session hash string,
+	// computed with the named Digest algorithm and server nonce, for
 	// the specified server
-	DigestSession(server string) (hash string)
+	SetDigestSession(server, nonce, algorithm, hash string)
+
+	// DigestSession returns the cached session hash string
+	// computed with the named Digest algorithm for the specified
+	// server, or the empty string if the hash was computed for a
+	// nonce other than the one specified.
+	DigestSession(server, nonce, algorithm string) (hash string)
 
 	// Duplicate creates n clones of rc.  The returned io.ReadCloser
 	// must be closed by the caller.  The original rc will always
@@ -62,12 +102,37 @@ type Session interface {
 type session struct {
 	sync.RWMutex
 	credentials Credentials
+	tokens      TokenSource
 	authcache   *AuthCache
-	md5cred     map[string]string
-	md5sess     map[string]string
+	proxycache  *AuthCache
+	digestCred  map[string][]digestCredential
+	digestSess  map[string]digestSession
+	tokenCache  map[string]cachedToken
+	tokenCalls  map[string]*tokenCall
 	counter     *NonceCounter
 	rcDir       string
 	rcLimit     int
+
+	// now returns the current time, and may be replaced in tests
+	now func() time.Time
+
+	// spills and spilled count the copies written to temporary
+	// files, and the bytes written.
+	spills  uint64
+	spilled uint64
+}
+
+// SessionStats counts the work done by a Session: the lookups of its
+// AuthCaches that found a cached challenge, and that did not, the
+// nonces evicted from its NonceCounter, and the copies of bodies
+// written to temporary files by its MemFileReadClosers, and the bytes
+// written.
+type SessionStats struct {
+	AuthCacheHits   uint64
+	AuthCacheMisses uint64
+	NonceEvictions  uint64
+	Spills          uint64
+	SpilledBytes    uint64
 }
 
 // NewSession returns an implementation of Session.  The provided
@@ -79,14 +144,28 @@ type session struct {
 // be written to a temporary file in dir.  If dir is the empty string,
 // the OS default temporary directory will be used.
 func NewSession(credentials Credentials, nonceCap int, dir string, limit int) Session {
+	return NewTokenSession(credentials, nil, nonceCap, dir, limit)
+}
+
+// NewTokenSession returns an implementation of Session that behaves
+// as one returned by NewSession, but which additionally consults
+// tokens for bearer tokens.  If tokens is an ExpiringTokenSource, its
+// tokens are cached in the Session until they expire, and concurrent
+// requests for the same token share a single call to NewToken.
+func NewTokenSession(credentials Credentials, tokens TokenSource, nonceCap int, dir string, limit int) Session {
 	return &session{
 		credentials: credentials,
+		tokens:      tokens,
 		authcache:   NewAuthCache(),
-		md5cred:     make(map[string]string),
-		md5sess:     make(map[string]string),
+		proxycache:  NewAuthCache(),
+		digestCred:  make(map[string][]digestCredential),
+		digestSess:  make(map[string]digestSession),
+		tokenCache:  make(map[string]cachedToken),
+		tokenCalls:  make(map[string]*tokenCall),
 		counter:     NewNonceCounter(nonceCap),
 		rcDir:       dir,
 		rcLimit:     limit,
+		now:         time.Now,
 	}
 }
 
@@ -94,6 +173,67 @@ func (session *session) Login(uri *url.URL, realm string) (username, password st
 	return session.credentials.Login(uri, realm)
 }
 
+func (session *session) Token(uri *url.URL, realm string, scope []string, refresh bool) (token string, err error) {
+	return session.TokenContext(context.Background(), uri, realm, scope, refresh)
+}
+
+// TokenContext performs the same work as Token, passing ctx to the
+// TokenSource if it implements ContextTokenSource.  A caller waiting
+// on a new token from an ExpiringTokenSource returns ctx.Err() if ctx
+// is done first, while the call to NewToken continues for the other
+// callers.
+func (session *session) TokenContext(ctx context.Context, uri *url.URL, realm string, scope []string, refresh bool) (token string, err error) {
+	if session.tokens == nil {
+		return "", NoCredentialsErr
+	}
+
+	tokens, ok := session.tokens.(ExpiringTokenSource)
+	if !ok {
+		return tokenContext(ctx, session.tokens, uri, realm, scope, refresh)
+	}
+
+	key := tokenKey(uri, realm, scope)
+
+	session.Lock()
+
+	if v, ok := session.tokenCache[key]; ok && !refresh {
+		if v.expiry.IsZero() || session.now().Before(v.expiry) {
+			session.Unlock()
+			return v.token, nil
+		}
+	}
+
+	call, ok := session.tokenCalls[key]
+	if !ok {
+		call = &tokenCall{done: make(chan struct{})}
+		session.tokenCalls[key] = call
+
+		go func() {
+			call.token.token, call.token.expiry, call.err = tokens.NewToken(uri, realm, scope)
+
+			session.Lock()
+			if call.err == nil {
+				session.tokenCache[key] = call.token
+			} else {
+				delete(session.tokenCache, key)
+			}
+			delete(session.tokenCalls, key)
+			session.Unlock()
+
+			close(call.done)
+		}()
+	}
+
+	session.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token.token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
 func (session *session) CNonce() (cnonce string, err error) {
 	buf := make([]byte, 12)
 	_, err = rand.Read(buf)
@@ -111,7 +251,7 @@ func (session *session) Counter(nonce string) string {
 	return fmt.Sprintf("%08x", n)
 }
 
-func (session *session) SetAuthorization(uri *url.URL, domain []string, auth string) {
+func (session *session) SetAuthorization(uri *url.URL, domain []string, challenge *Challenge, auth string) {
 	session.Lock()
 	defer session.Unlock()
 
@@ -125,61 +265,131 @@ func (session *session) SetAuthorization(uri *url.URL, domain []string, auth str
 			RawQuery: "",
 			Fragment: "",
 		}
-		session.authcache.Set(root, auth)
+		session.authcache.Set(root, challenge, auth)
 		return
 	}
 
 	for _, s := range domain {
 		ref, err := url.Parse(s)
 		if err == nil {
-			session.authcache.Set(uri.ResolveReference(ref), auth)
+			session.authcache.Set(uri.ResolveReference(ref), challenge, auth)
 		}
 	}
 }
 
-func (session *session) Authorization(uri *url.URL) (auth string) {
+func (session *session) Authorization(uri *url.URL) (challenge *Challenge, auth string) {
 	session.RLock()
 	defer session.RUnlock()
 	return session.authcache.Get(uri)
 }
 
-func (session *session) SetDigestCredentials(uri *url.URL, domain []string, hash string) {
+func (session *session) SetProxyAuthorization(proxy *url.URL, challenge *Challenge, auth string) {
+	session.Lock()
+	defer session.Unlock()
+	session.proxycache.Set(&url.URL{Scheme: proxy.Scheme, Host: proxy.Host, Path: "/"}, challenge, auth)
+}
+
+func (session *session) ProxyAuthorization(proxy *url.URL) (challenge *Challenge, auth string) {
+	session.RLock()
+	defer session.RUnlock()
+	return session.proxycache.Get(&url.URL{Scheme: proxy.Scheme, Host: proxy.Host, Path: "/"})
+}
+
+func (session *session) SetDigestCredentials(uri *url.URL, domain []string, realm, algorithm, username, hash string) {
 	if len(domain) == 0 {
 		domain = append(domain, "/")
 	}
 
-	spaces := make([]string, len(domain))
+	session.Lock()
+	defer session.Unlock()
+
 	for _, s := range domain {
 		ref, err := url.Parse(s)
-		if err == nil {
-			abs := uri.ResolveReference(ref)
-			spaces = append(spaces, abs.Host+":"+abs.Path)
+		if err != nil {
+			continue
 		}
+		abs := uri.ResolveReference(ref)
+
+		key := digestKey(algorithm, abs.Host+" "+realm)
+		session.digestCred[key] = setDigestCredential(session.digestCred[key], digestCredential{abs.Path, username, hash})
 	}
+}
 
-	session.Lock()
-	defer session.Unlock()
-	for _, space := range spaces {
-		session.md5cred[space] = hash
+// setDigestCredential returns creds with cred replacing any cached
+// for the same path.
+func setDigestCredential(creds []digestCredential, cred digestCredential) []digestCredential {
+	for i := range creds {
+		if creds[i].path == cred.path {
+			creds[i] = cred
+			return creds
+		}
 	}
+	return append(creds, cred)
 }
 
-func (session *session) DigestCredentials(uri *url.URL) (hash string) {
+func (session *session) DigestCredentials(uri *url.URL, realm, algorithm string) (username, hash string) {
+	path := uri.Path
+	if path == "" {
+		path = "/"
+	}
+
 	session.RLock()
 	defer session.RUnlock()
-	return session.md5cred[uri.Host+":/"]
+
+	var match *digestCredential
+	creds := session.digestCred[digestKey(algorithm, uri.Host+" "+realm)]
+	for i, v := range creds {
+		if (match == nil || len(v.path) > len(match.path)) && (AuthPath{Path: v.path}).Matches(path) {
+			match = &creds[i]
+		}
+	}
+	if match == nil {
+		return "", ""
+	}
+	return match.username, match.hash
 }
 
-func (session *session) SetDigestSession(server, hash string) {
+func (session *session) SetDigestSession(server, nonce, algorithm, hash string) {
 	session.Lock()
 	defer session.Unlock()
-	session.md5sess[server] = hash
+	session.digestSess[digestKey(algorithm, server)] = digestSession{nonce, hash}
 }
 
-func (session *session) DigestSession(server string) (hash string) {
+func (session *session) DigestSession(server, nonce, algorithm string) (hash string) {
 	session.RLock()
 	defer session.RUnlock()
-	return session.md5sess[server]
+	v := session.digestSess[digestKey(algorithm, server)]
+	if v.nonce != nonce {
+		return ""
+	}
+	return v.hash
+}
+
+// digestCredential holds a username and its H(A1) hash, cached for
+// the domain path
+type digestCredential struct {
+	path     string
+	username string
+	hash     string
+}
+
+// digestSession holds a session H(A1) hash and the nonce it is
+// bound to
+type digestSession struct {
+	nonce string
+	hash  string
+}
+
+// digestKey qualifies key with the Digest algorithm, so that hashes
+// computed by different algorithms are never confused.  The "-sess"
+// suffix is dropped, as H(A1) is the same for both variants.
+func digestKey(algorithm, key string) string {
+	algorithm = strings.ToUpper(algorithm)
+	algorithm = strings.TrimSuffix(algorithm, "-SESS")
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	return algorithm + " " + key
 }
 
 func (session *session) Duplicate(rc io.ReadCloser, n int) (clone []io.ReadCloser, err error) {
@@ -235,5 +445,30 @@ func (session *session) Duplicate(rc io.ReadCloser, n int) (clone []io.ReadClose
 }
 
 func (session *session) NewProxyReadCloser() ProxyReadCloser {
-	return NewMemFileReadCloser(session.rcDir, session.rcLimit)
+	w := NewMemFileReadCloser(session.rcDir, session.rcLimit)
+	w.onSpill = session.spill
+	return w
+}
+
+// spill counts a copy of n bytes written to a temporary file
+func (session *session) spill(n int64) {
+	atomic.AddUint64(&session.spills, 1)
+	atomic.AddUint64(&session.spilled, uint64(n))
+}
+
+// Stats returns the counts of the work done by the session.
+func (session *session) Stats() (stats SessionStats) {
+	session.RLock()
+	defer session.RUnlock()
+
+	hits, misses := session.authcache.Stats()
+	proxyHits, proxyMisses := session.proxycache.Stats()
+
+	return SessionStats{
+		AuthCacheHits:   hits + proxyHits,
+		AuthCacheMisses: misses + proxyMisses,
+		NonceEvictions:  session.counter.Evictions(),
+		Spills:          atomic.LoadUint64(&session.spills),
+		SpilledBytes:    atomic.LoadUint64(&session.spilled),
+	}
 }
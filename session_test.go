@@ -0,0 +1,68 @@
+package httpclient
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestSetDigestCredentials(t *testing.T) {
+	tests := []struct {
+		Domain []string
+		URL    string
+		Realm  string
+		Hash   string
+	}{
+		{nil, "http://example.com/", "realm", "hash"},
+		{nil, "http://example.com", "realm", "hash"},
+		{nil, "http://example.com/a/b/c", "realm", "hash"},
+		{nil, "http://example.com/", "other", ""},
+		{nil, "http://example.org/", "realm", ""},
+		{[]string{"/a/", "http://example.com/b/"}, "http://example.com/a/index.html", "realm", "hash"},
+		{[]string{"/a/", "http://example.com/b/"}, "http://example.com/b/c/d", "realm", "hash"},
+		{[]string{"/a/", "http://example.com/b/"}, "http://example.com/c/", "realm", ""},
+		{[]string{"/a/", "http://example.com/b/"}, "http://example.com/ab", "realm", ""},
+	}
+
+	uri, _ := url.Parse("http://example.com/a/index.html")
+
+	for i, v := range tests {
+		s := NewSession(&OrderedCredentials{}, 1000, "", -1)
+		s.SetDigestCredentials(uri, v.Domain, "realm", "SHA-256-sess", "Mufasa", "hash")
+
+		lookup, _ := url.Parse(v.URL)
+		username, hash := s.DigestCredentials(lookup, v.Realm, "SHA-256")
+		if hash != v.Hash {
+			t.Errorf("%d: expected hash %q for %s, got %q", i, v.Hash, v.URL, hash)
+		}
+		if hash != "" && username != "Mufasa" {
+			t.Errorf("%d: expected username Mufasa, got %q", i, username)
+		}
+	}
+}
+
+// TestDigestCredentialsLongest checks the credentials cached for the
+// longest matching domain path are returned.
+func TestDigestCredentialsLongest(t *testing.T) {
+	s := NewSession(&OrderedCredentials{}, 1000, "", -1)
+
+	uri, _ := url.Parse("http://example.com/")
+	s.SetDigestCredentials(uri, nil, "realm", "MD5", "root", "hash-root")
+	s.SetDigestCredentials(uri, []string{"/a/b/"}, "realm", "MD5", "ab", "hash-ab")
+	s.SetDigestCredentials(uri, []string{"/a/"}, "realm", "MD5", "a", "hash-a")
+
+	tests := []struct {
+		URL      string
+		Username string
+	}{
+		{"http://example.com/x", "root"},
+		{"http://example.com/a/x", "a"},
+		{"http://example.com/a/b/x", "ab"},
+	}
+
+	for i, v := range tests {
+		lookup, _ := url.Parse(v.URL)
+		if username, _ := s.DigestCredentials(lookup, "realm", "MD5"); username != v.Username {
+			t.Errorf("%d: expected %s, got %s", i, v.Username, username)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package httpclient
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Signer signs a request before it is sent by Client.Do.  Sign may
+// replace req.Body, but must leave it readable.
+type Signer interface {
+	Sign(req *http.Request) (err error)
+}
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4DateFormat = "20060102T150405Z"
+)
+
+// SigV4 implements Signer using AWS Signature Version 4.  The access
+// key id and secret access key are looked up via Session.Login, as
+// the username and password for the request URL, and the request
+// body is buffered via Session.NewProxyReadCloser so that it may be
+// hashed and still sent.
+//
+// SigV4 sets the Authorization header, replacing any set by DoAuth in
+// answer to a challenge, as the Signer is applied to each request
+// after DoAuth has authorized it.  Use Do, not DoAuth, with a Client
+// signing with SigV4.
+//
+// Only the Host, Content-Type and X-Amz-* headers are signed, and any
+// listed in Headers.  Others, such as the Proxy-Authorization answering
+// a proxy challenge and the hop-by-hop headers, may be changed or
+// removed on the way to the server, and would break the signature.
+type SigV4 struct {
+	session Session
+	region  string
+	service string
+
+	// Headers lists further headers to sign, by name.
+	Headers []string
+
+	// now returns the current time, and may be replaced in tests
+	now func() time.Time
+}
+
+// NewSigV4 returns a SigV4 signing requests for the specified AWS
+// region and service.
+func NewSigV4(session Session, region, service string) *SigV4 {
+	return &SigV4{
+		session: session,
+		region:  region,
+		service: service,
+		now:     time.Now,
+	}
+}
+
+// Sign adds the X-Amz-Date, X-Amz-Content-Sha256 and Authorization
+// headers to req.
+func (s *SigV4) Sign(req *http.Request) (err error) {
+	accessKey, secretKey, err := s.session.Login(req.URL, "")
+	if err != nil {
+		return
+	}
+
+	h := sha256.New()
+	if req.Body != nil {
+		prc := s.session.NewProxyReadCloser()
+		mw := io.MultiWriter(h, prc)
+
+		_, err = io.Copy(mw, req.Body)
+		req.Body.Close()
+		if err != nil {
+			return
+		}
+
+		err = prc.Close()
+		if err != nil {
+			return
+		}
+
+		req.Body, err = prc.ReadCloser()
+		if err != nil {
+			return
+		}
+	}
+	payloadHash := fmt.Sprintf("%x", h.Sum(nil))
+
+	req.Header.Set("X-Amz-Date", s.now().UTC().Format(sigV4DateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Del("Authorization")
+
+	auth, err := s.authorization(req, payloadHash, accessKey, secretKey)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Authorization", auth)
+
+	return nil
+}
+
+// authorization returns the Authorization header value for req,
+// which must already carry its X-Amz-Date header.
+func (s *SigV4) authorization(req *http.Request, payloadHash, accessKey, secretKey string) (auth string, err error) {
+	amzDate := req.Header.Get("X-Amz-Date")
+	t, err := time.Parse(sigV4DateFormat, amzDate)
+	if err != nil {
+		err = fmt.Errorf("unable to parse X-Amz-Date %s: %v", amzDate, err)
+		return
+	}
+	date := t.Format("20060102")
+
+	creq, signedHeaders, err := s.canonicalRequest(req, payloadHash)
+	if err != nil {
+		return
+	}
+
+	scope := strings.Join([]string{date, s.region, s.service, "aws4_request"}, "/")
+
+	sts := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		fmt.Sprintf("%x", sha256.Sum256([]byte(creq))),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := fmt.Sprintf("%x", hmacSHA256(key, sts))
+
+	auth = fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, accessKey, scope, signedHeaders, signature)
+
+	return auth, nil
+}
+
+// canonicalRequest returns the SigV4 canonical request for req, and
+// the list of signed headers.
+func (s *SigV4) canonicalRequest(req *http.Request, payloadHash string) (creq string, signedHeaders string, err error) {
+	buf := &bytes.Buffer{}
+
+	buf.WriteString(req.Method)
+	buf.WriteString("\n")
+
+	// canonical uri, normalized for every service but S3, which
+	// treats the path as an opaque object key
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	if s.service != "s3" {
+		path = removeDotSegments(path)
+	}
+	segments := strings.Split(path, "/")
+	for i := range segments {
+		segments[i] = sigV4Escape(segments[i])
+	}
+	buf.WriteString(strings.Join(segments, "/"))
+	buf.WriteString("\n")
+
+	// canonical query string, sorted by key and then by value
+	query, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		err = fmt.Errorf("unable to parse %s query: %v", req.URL.String(), err)
+		return
+	}
+	params := make([][2]string, 0, len(query))
+	for k, values := range query {
+		for _, v := range values {
+			params = append(params, [2]string{sigV4Escape(k), sigV4Escape(v)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	for i, p := range params {
+		if i > 0 {
+			buf.WriteString("&")
+		}
+		buf.WriteString(p[0])
+		buf.WriteString("=")
+		buf.WriteString(p[1])
+	}
+	buf.WriteString("\n")
+
+	// canonical headers
+	headers := make(map[string]string)
+	for k, values := range req.Header {
+		k = strings.ToLower(k)
+		if !s.signed(k) {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[k] = strings.Join(trimmed, ",")
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers["host"] = host
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		buf.WriteString(k)
+		buf.WriteString(":")
+		buf.WriteString(headers[k])
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+
+	signedHeaders = strings.Join(names, ";")
+	buf.WriteString(signedHeaders)
+	buf.WriteString("\n")
+
+	buf.WriteString(payloadHash)
+
+	return buf.String(), signedHeaders, nil
+}
+
+// signed reports whether the header named by the lowercase name is
+// signed.
+func (s *SigV4) signed(name string) bool {
+	if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+		return true
+	}
+	for _, v := range s.Headers {
+		if strings.EqualFold(v, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeDotSegments removes the "." and ".." segments of path, per
+// RFC 3986 5.2.4.
+func removeDotSegments(path string) string {
+	segments := strings.Split(path, "/")
+	last := len(segments) - 1
+
+	out := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		switch segment {
+		case ".":
+		case "..":
+			// keep the empty segment before the leading slash
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, segment)
+			continue
+		}
+		// a path ending in a dot segment names a directory
+		if i == last {
+			out = append(out, "")
+		}
+	}
+
+	return strings.Join(out, "/")
+}
+
+// sigV4Escape percent-encodes every byte of s other than the RFC
+// 3986 unreserved characters.
+func sigV4Escape(s string) string {
+	buf := &bytes.Buffer{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,251 @@
+package httpclient
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sigV4Tests are drawn from the AWS Signature Version 4 test suite,
+// which signs with the credentials AKIDEXAMPLE and
+// wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY for us-east-1 "service".
+var sigV4Tests = []struct {
+	Name      string
+	Method    string
+	URL       string
+	Header    map[string]string
+	Body      string
+	Signed    string
+	Signature string
+}{
+	{"get-vanilla", "GET", "https://example.amazonaws.com/", nil, "",
+		"host;x-amz-date",
+		"5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+	{"get-vanilla-query-order-key-case", "GET", "https://example.amazonaws.com/?Param2=value2&Param1=value1", nil, "",
+		"host;x-amz-date",
+		"b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	{"get-vanilla-query-order-key", "GET", "https://example.amazonaws.com/?Param1=value2&Param1=Value1", nil, "",
+		"host;x-amz-date",
+		"eedbc4e291e521cf13422ffca22be7d2eb8146eecf653089df300a15b2382bd1"},
+	{"get-utf8", "GET", "https://example.amazonaws.com/ሴ", nil, "",
+		"host;x-amz-date",
+		"8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85"},
+	{"get-space", "GET", "https://example.amazonaws.com/example%20space/", nil, "",
+		"host;x-amz-date",
+		"652487583200325589f1fba4c7e578f72c47cb61beeca81406b39ddec1366741"},
+	{"normalize-path/get-relative", "GET", "https://example.amazonaws.com/example/..", nil, "",
+		"host;x-amz-date",
+		"5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+	{"normalize-path/get-relative-relative", "GET", "https://example.amazonaws.com/example1/example2/../..", nil, "",
+		"host;x-amz-date",
+		"5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+	{"normalize-path/get-slash-dot-slash", "GET", "https://example.amazonaws.com/./", nil, "",
+		"host;x-amz-date",
+		"5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+	{"get-header-value-trim", "GET", "https://example.amazonaws.com/",
+		map[string]string{"My-Header1": " value1", "My-Header2": `"a   b   c"`}, "",
+		"host;my-header1;my-header2;x-amz-date",
+		"acc3ed3afb60bb290fc8d2dd0098b9911fcaa05412b367055dee359757a9c736"},
+	{"post-vanilla", "POST", "https://example.amazonaws.com/", nil, "",
+		"host;x-amz-date",
+		"5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+	{"post-x-www-form-urlencoded", "POST", "https://example.amazonaws.com/",
+		map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "Param1=value1",
+		"content-type;host;x-amz-date",
+		"ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"},
+}
+
+// newSigV4Test returns a SigV4 signing as the test suite does
+func newSigV4Test() *SigV4 {
+	credentials := &OrderedCredentials{[]Credential{NewCredential("", "", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")}}
+	s := NewSigV4(NewSession(credentials, 1000, "", -1), "us-east-1", "service")
+	s.Headers = []string{"My-Header1", "My-Header2"}
+	s.now = func() time.Time {
+		return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	}
+	return s
+}
+
+// TestSigV4TestSuite checks the signature of each of the test suite
+// requests.  The suite does not send X-Amz-Content-Sha256, which Sign
+// adds, so the signature is computed directly.
+func TestSigV4TestSuite(t *testing.T) {
+	s := newSigV4Test()
+
+	for _, v := range sigV4Tests {
+		req, err := http.NewRequest(v.Method, v.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, h := range v.Header {
+			req.Header.Set(k, h)
+		}
+		req.Header.Set("X-Amz-Date", "20150830T123600Z")
+
+		payloadHash := fmt.Sprintf("%x", sha256.Sum256([]byte(v.Body)))
+		auth, err := s.authorization(req, payloadHash, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+		if err != nil {
+			t.Fatalf("%s: %v", v.Name, err)
+		}
+
+		expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+			"SignedHeaders=" + v.Signed + ", Signature=" + v.Signature
+		if auth != expected {
+			t.Errorf("%s: expected [%s], got [%s]", v.Name, expected, auth)
+		}
+	}
+}
+
+// TestSigV4SignTransport signs each of the test suite requests via a
+// SignTransport, along with headers that are not to be signed, and
+// checks the request sent carries the signature of its headers.
+func TestSigV4SignTransport(t *testing.T) {
+	s := newSigV4Test()
+
+	var sent *http.Request
+	rt := Chain(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = req
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}), Sign(s))
+
+	for _, v := range sigV4Tests {
+		req, err := http.NewRequest(v.Method, v.URL, strings.NewReader(v.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, h := range v.Header {
+			req.Header.Set(k, h)
+		}
+		req.Header.Set("Proxy-Authorization", "Basic cHJveHk6c2VjcmV0")
+		req.Header.Set("Connection", "keep-alive")
+		req.Header.Set("User-Agent", "test")
+
+		rsp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%s: %v", v.Name, err)
+		}
+		rsp.Body.Close()
+
+		payloadHash := fmt.Sprintf("%x", sha256.Sum256([]byte(v.Body)))
+		if h := sent.Header.Get("X-Amz-Content-Sha256"); h != payloadHash {
+			t.Errorf("%s: expected X-Amz-Content-Sha256 %s, got %s", v.Name, payloadHash, h)
+		}
+
+		// the headers a proxy may change do not alter the signature
+		auth := sent.Header.Get("Authorization")
+		sent.Header.Del("Proxy-Authorization")
+		sent.Header.Del("Connection")
+		sent.Header.Del("User-Agent")
+
+		expected, err := s.authorization(sent, payloadHash, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+		if err != nil {
+			t.Fatalf("%s: %v", v.Name, err)
+		}
+		if auth != expected {
+			t.Errorf("%s: expected [%s], got [%s]", v.Name, expected, auth)
+		}
+
+		signed := strings.Replace(v.Signed, ";x-amz-date", ";x-amz-content-sha256;x-amz-date", 1)
+		if !strings.Contains(auth, "SignedHeaders="+signed+",") {
+			t.Errorf("%s: expected SignedHeaders=%s, got [%s]", v.Name, signed, auth)
+		}
+	}
+}
+
+func TestSigV4CanonicalRequest(t *testing.T) {
+	tests := []struct {
+		Service string
+		URL     string
+		Path    string
+		Query   string
+	}{
+		// keys sort before values, so a key that is a prefix of
+		// another sorts first
+		{"service", "https://example.com/?a1=x&a=y", "/", "a=y&a1=x"},
+		{"service", "https://example.com/?b=2&a=2&a=1", "/", "a=1&a=2&b=2"},
+		{"service", "https://example.com/a/./b/../c/", "/a/c/", ""},
+		{"service", "https://example.com/a/b/..", "/a/", ""},
+		{"service", "https://example.com/../..", "/", ""},
+		// S3 object keys are not normalized
+		{"s3", "https://example.com/a/./b/../c", "/a/./b/../c", ""},
+	}
+
+	for i, v := range tests {
+		s := NewSigV4(nil, "us-east-1", v.Service)
+
+		req, err := http.NewRequest("GET", v.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		creq, _, err := s.canonicalRequest(req, "")
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		lines := strings.Split(creq, "\n")
+		if lines[1] != v.Path {
+			t.Errorf("%d: expected path %s, got %s", i, v.Path, lines[1])
+		}
+		if lines[2] != v.Query {
+			t.Errorf("%d: expected query %s, got %s", i, v.Query, lines[2])
+		}
+	}
+
+	s := NewSigV4(nil, "us-east-1", "service")
+	req, _ := http.NewRequest("GET", "https://example.com/?a=%zz", nil)
+	if _, _, err := s.canonicalRequest(req, ""); err == nil {
+		t.Errorf("expected an error for a malformed query")
+	}
+}
+
+func TestSigV4Sign(t *testing.T) {
+	body := "Action=ListUsers&Version=2010-05-08"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if string(b) != body {
+			t.Errorf("expected body %q, got %q", body, string(b))
+		}
+		if s := req.Header.Get("X-Amz-Date"); s != "20150830T123600Z" {
+			t.Errorf("unexpected X-Amz-Date %s", s)
+		}
+		if s := req.Header.Get("X-Amz-Content-Sha256"); s != fmt.Sprintf("%x", sha256.Sum256([]byte(body))) {
+			t.Errorf("unexpected X-Amz-Content-Sha256 %s", s)
+		}
+		if s := req.Header.Get("Authorization"); !strings.Contains(s, "SignedHeaders=host;x-amz-content-sha256;x-amz-date,") {
+			t.Errorf("unexpected Authorization %s", s)
+		}
+	}))
+	defer server.Close()
+
+	credentials := &OrderedCredentials{[]Credential{NewCredential("", "", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")}}
+
+	// a limit of 8 bytes spills the body to a temporary file
+	s := NewSigV4(NewSession(credentials, 1000, "", 8), "us-east-1", "iam")
+	s.now = func() time.Time {
+		return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	}
+
+	client := NewClient(time.Duration(1 * time.Second))
+	client.Signer = s
+
+	req, err := http.NewRequest("POST", server.URL+"/", ioutil.NopCloser(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+}
@@ -0,0 +1,371 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The metrics reported to a Meter.  Durations are in seconds, as the
+// OpenTelemetry HTTP semantic conventions specify.
+const (
+	// MetricRequestDuration is a histogram of the duration of each
+	// logical request, up to the close of the response body.
+	MetricRequestDuration = "http.client.request.duration"
+
+	// MetricRequests counts the requests sent, including each
+	// retry, redirect and resend to answer a challenge, by status.
+	MetricRequests = "httpclient.requests"
+
+	// MetricAuthChallenges counts the 401 and 407 challenges
+	// received, by scheme.
+	MetricAuthChallenges = "httpclient.auth.challenges"
+
+	// MetricAuthCacheLookups counts the lookups of the Session
+	// AuthCache made before sending a request, by whether
+	// authorization was found.
+	MetricAuthCacheLookups = "httpclient.auth.cache.lookups"
+
+	// MetricTimeouts counts the timeouts fired, by phase.
+	MetricTimeouts = "httpclient.timeouts"
+)
+
+// The attributes set on spans and metrics, beyond those of the
+// OpenTelemetry HTTP semantic conventions.
+const (
+	AttributeAuthScheme   = "httpclient.auth.scheme"
+	AttributeAuthCacheHit = "httpclient.auth.cache.hit"
+	AttributeTimeoutPhase = "httpclient.timeout.phase"
+)
+
+// Attribute describes a span or measurement.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Tracer starts spans.  Start returns ctx carrying the new span, so
+// that spans started with it are children of the new span.
+//
+// Tracer, Span and Meter are this package's own interfaces, and not
+// those of OpenTelemetry, on which the package does not depend.  To
+// report to OpenTelemetry, implement them over a trace.Tracer and a
+// metric.Meter.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.  RecordError records err and
+// marks the span as failed.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Meter records measurements.  Add adds n to the counter name, and
+// Record records v in the histogram name.
+type Meter interface {
+	Add(ctx context.Context, name string, n int64, attrs ...Attribute)
+	Record(ctx context.Context, name string, v float64, attrs ...Attribute)
+}
+
+// meters is a Meter recording each measurement to each of its Meters
+type meters []Meter
+
+func (m meters) Add(ctx context.Context, name string, n int64, attrs ...Attribute) {
+	for _, meter := range m {
+		meter.Add(ctx, name, n, attrs...)
+	}
+}
+
+func (m meters) Record(ctx context.Context, name string, v float64, attrs ...Attribute) {
+	for _, meter := range m {
+		meter.Record(ctx, name, v, attrs...)
+	}
+}
+
+// Telemetry reports spans and metrics for the requests sent by a
+// Client.  Each call to Do or DoAuth starts a span for the logical
+// request, ending when the response body is closed, with a child
+// span for each request sent: the first attempt, and each retry and
+// resend.  A resend answering a challenge is made within a further
+// span for that authentication round trip.  Spans carry the
+// OpenTelemetry HTTP semantic convention attributes, with the values
+// of the query redacted from url.full.
+//
+// DoAuth follows redirects itself, and so sends each redirect within
+// a span of its own.  Do leaves redirects to the http.Client, within
+// the span of the request redirected.
+//
+// The CONNECT request for an https tunnel is sent by the Transport as
+// it dials, and has no span of its own.  A proxy challenge to it is
+// counted with the request being tunneled.
+//
+// Either of Tracer and Meter may be nil, and a nil Telemetry reports
+// nothing.
+type Telemetry struct {
+	Tracer Tracer
+	Meter  Meter
+}
+
+// WithTelemetry sets the Telemetry reporting on each request.
+func WithTelemetry(telemetry *Telemetry) Option {
+	return func(hr *Client) {
+		hr.Telemetry = telemetry
+	}
+}
+
+type telemetryTraceKey struct{}
+
+// telemetryTrace carries the Telemetry of a logical request, and its
+// span, in the request context.
+type telemetryTrace struct {
+	telemetry *Telemetry
+	span      Span
+	start     time.Time
+	sent      int32
+}
+
+// start returns ctx carrying a span for the logical request req.
+// It returns ctx unchanged if telemetry is nil.
+func (telemetry *Telemetry) start(ctx context.Context, req *http.Request) (context.Context, *telemetryTrace) {
+	if telemetry == nil {
+		return ctx, nil
+	}
+	t := &telemetryTrace{telemetry: telemetry, start: time.Now()}
+	ctx, t.span = t.startSpan(ctx, req.Method, requestAttributes(req)...)
+	return context.WithValue(ctx, telemetryTraceKey{}, t), t
+}
+
+// telemetryFrom returns the telemetryTrace carried by ctx, or nil.
+// Each of the telemetryTrace methods is a no-op on nil.
+func telemetryFrom(ctx context.Context) *telemetryTrace {
+	t, _ := ctx.Value(telemetryTraceKey{}).(*telemetryTrace)
+	return t
+}
+
+func (t *telemetryTrace) startSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	if t.telemetry.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return t.telemetry.Tracer.Start(ctx, name, attrs...)
+}
+
+func (t *telemetryTrace) add(ctx context.Context, name string, attrs ...Attribute) {
+	if t != nil && t.telemetry.Meter != nil {
+		t.telemetry.Meter.Add(ctx, name, 1, attrs...)
+	}
+}
+
+// complete arranges for the span of the logical request to end once
+// rsp is finished with: immediately if there is no response,
+// otherwise when the response body is closed.
+func (t *telemetryTrace) complete(req *http.Request, rsp *http.Response, err error) (*http.Response, error) {
+	if t == nil {
+		return rsp, err
+	}
+	if err != nil || rsp == nil {
+		t.end(req, rsp, err)
+		return rsp, err
+	}
+	rsp.Body = &telemetryReadCloser{ReadCloser: rsp.Body, t: t, req: req, rsp: rsp}
+	return rsp, nil
+}
+
+// end ends the span of the logical request, and records its duration
+func (t *telemetryTrace) end(req *http.Request, rsp *http.Response, err error) {
+	attrs := responseAttributes(rsp, err)
+	t.span.SetAttributes(attrs...)
+	if err != nil {
+		t.span.RecordError(err)
+	}
+	t.span.End()
+
+	if t.telemetry.Meter != nil {
+		attrs = append(attrs,
+			Attribute{"http.request.method", req.Method},
+			Attribute{"server.address", req.URL.Hostname()})
+		t.telemetry.Meter.Record(req.Context(), MetricRequestDuration, time.Since(t.start).Seconds(), attrs...)
+	}
+}
+
+// send sends req via rt within a span for the attempt, which ends
+// once the response headers have been received.
+func (t *telemetryTrace) send(rt http.RoundTripper, req *http.Request) (rsp *http.Response, err error) {
+	attrs := requestAttributes(req)
+	if n := atomic.AddInt32(&t.sent, 1) - 1; n > 0 {
+		attrs = append(attrs, Attribute{"http.request.resend_count", int(n)})
+	}
+
+	ctx, span := t.startSpan(req.Context(), req.Method, attrs...)
+
+	rsp, err = rt.RoundTrip(req.WithContext(ctx))
+
+	attrs = responseAttributes(rsp, err)
+	span.SetAttributes(attrs...)
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+
+	t.add(req.Context(), MetricRequests, append(attrs,
+		Attribute{"http.request.method", req.Method},
+		Attribute{"server.address", req.URL.Hostname()})...)
+
+	return
+}
+
+// challenge reports the challenges received in a response with status
+func (t *telemetryTrace) challenge(req *http.Request, status int, challenges Challenges) {
+	for _, challenge := range challenges {
+		t.add(req.Context(), MetricAuthChallenges,
+			Attribute{AttributeAuthScheme, challenge.Scheme},
+			Attribute{"http.response.status_code", status})
+	}
+}
+
+// timeout reports the timeout err
+func (t *telemetryTrace) timeout(req *http.Request, err *TimeoutError) {
+	t.add(req.Context(), MetricTimeouts, Attribute{AttributeTimeoutPhase, string(err.Phase)})
+}
+
+// cached reports a lookup of the AuthCache
+func (t *telemetryTrace) cached(req *http.Request, hit bool) {
+	t.add(req.Context(), MetricAuthCacheLookups, Attribute{AttributeAuthCacheHit, hit})
+}
+
+// answer returns req with a context carrying a span for the round
+// trip answering challenge, and a function ending the span.
+func (t *telemetryTrace) answer(req *http.Request, challenge *Challenge) (*http.Request, func(rsp *http.Response, err error)) {
+	if t == nil {
+		return req, func(*http.Response, error) {}
+	}
+	ctx, span := t.startSpan(req.Context(), "auth "+challenge.Scheme, Attribute{AttributeAuthScheme, challenge.Scheme})
+	return req.WithContext(ctx), func(rsp *http.Response, err error) {
+		span.SetAttributes(responseAttributes(rsp, err)...)
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}
+}
+
+// requestAttributes returns the semantic convention attributes of req
+func requestAttributes(req *http.Request) []Attribute {
+	attrs := []Attribute{
+		{"http.request.method", req.Method},
+		{"url.full", redactURL(req)},
+		{"server.address", req.URL.Hostname()},
+	}
+	port := req.URL.Port()
+	if port == "" {
+		switch req.URL.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	if n, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, Attribute{"server.port", n})
+	}
+	return attrs
+}
+
+// redactURL returns the URL of req without any userinfo, and with the
+// value of each query parameter replaced by REDACTED, as a query may
+// carry credentials, e.g., an access_token or X-Amz-Signature.  A
+// query component without a value, which may itself be a credential,
+// is replaced whole.
+func redactURL(req *http.Request) string {
+	if req.URL.User == nil && req.URL.RawQuery == "" {
+		return req.URL.String()
+	}
+	u := *req.URL
+	u.User = nil
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, param := range params {
+			if j := strings.IndexByte(param, '='); j >= 0 {
+				params[i] = param[:j+1] + "REDACTED"
+			} else if param != "" {
+				params[i] = "REDACTED"
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	return u.String()
+}
+
+// responseAttributes returns the semantic convention attributes of
+// rsp, or of the error err.
+func responseAttributes(rsp *http.Response, err error) (attrs []Attribute) {
+	if err != nil {
+		return []Attribute{{"error.type", errorType(err)}}
+	}
+	if rsp == nil {
+		return nil
+	}
+	attrs = append(attrs, Attribute{"http.response.status_code", rsp.StatusCode})
+	if rsp.StatusCode >= 400 {
+		attrs = append(attrs, Attribute{"error.type", strconv.Itoa(rsp.StatusCode)})
+	}
+	return
+}
+
+// errorType returns the error.type attribute for err
+func errorType(err error) string {
+	if isTimeout(err) {
+		return "timeout"
+	}
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+// telemetryReadCloser ends the span of the logical request when the
+// wrapped body is first closed.
+type telemetryReadCloser struct {
+	io.ReadCloser
+	t    *telemetryTrace
+	req  *http.Request
+	rsp  *http.Response
+	once sync.Once
+}
+
+func (rc *telemetryReadCloser) Close() error {
+	err := rc.ReadCloser.Close()
+	rc.once.Do(func() {
+		rc.t.end(rc.req, rc.rsp, nil)
+	})
+	return err
+}
+
+// telemetryTransport starts a span for each request it sends on
+// behalf of a logical request carrying a telemetryTrace.
+type telemetryTransport struct {
+	Base http.RoundTripper
+}
+
+func (t telemetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := telemetryFrom(req.Context())
+	if trace == nil {
+		return base(t.Base).RoundTrip(req)
+	}
+	return trace.send(base(t.Base), req)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
@@ -0,0 +1,86 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Phase identifies the stage of a request at which a timeout expired.
+type Phase string
+
+const (
+	PhaseDial    Phase = "dial"
+	PhaseTLS     Phase = "tls handshake"
+	PhaseHeaders Phase = "response headers"
+	PhaseBody    Phase = "response body"
+)
+
+// TimeoutError is returned when a request or the read of a response
+// body times out.  It implements net.Error, and matches
+// context.DeadlineExceeded with errors.Is.
+type TimeoutError struct {
+	URL     string
+	Phase   Phase
+	Elapsed time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	s := fmt.Sprintf("error requesting %s: %s timed out after %s", e.URL, e.Phase, e.Elapsed)
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+func (e *TimeoutError) Timeout() bool   { return true }
+func (e *TimeoutError) Temporary() bool { return true }
+func (e *TimeoutError) Unwrap() error   { return e.Err }
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// isTimeout reports whether err is the result of a deadline expiring
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// phaseTracker records the phase a request has reached, via an
+// httptrace.ClientTrace.
+type phaseTracker struct {
+	mu    sync.Mutex
+	phase Phase
+}
+
+func (p *phaseTracker) set(phase Phase) {
+	p.mu.Lock()
+	p.phase = phase
+	p.mu.Unlock()
+}
+
+func (p *phaseTracker) get() Phase {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.phase
+}
+
+// trace returns ctx carrying a ClientTrace that updates p, composed
+// with any trace already present in ctx.
+func (p *phaseTracker) trace(ctx context.Context) context.Context {
+	p.set(PhaseDial)
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn:           func(string) { p.set(PhaseDial) },
+		TLSHandshakeStart: func() { p.set(PhaseTLS) },
+		GotConn:           func(httptrace.GotConnInfo) { p.set(PhaseHeaders) },
+	})
+}
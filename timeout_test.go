@@ -0,0 +1,151 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimeoutError(t *testing.T) {
+	// accepts connections, but never completes a TLS handshake
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	go func() {
+		for {
+			conn, err := stalled.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	server := httptest.NewServer(&httpTestHandler{Mutex: new(sync.Mutex)})
+	defer server.CloseClientConnections()
+	defer server.Close()
+
+	timeout := 100 * time.Millisecond
+
+	tests := []struct {
+		URL   string
+		Dial  bool
+		Phase Phase
+	}{
+		{server.URL, true, PhaseDial},
+		{"https://" + stalled.Addr().String() + "/", false, PhaseTLS},
+		{server.URL, false, PhaseHeaders},
+	}
+
+	for i, v := range tests {
+		client := NewClient(timeout)
+		if v.Dial {
+			client.Transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+		}
+
+		req, err := http.NewRequest("GET", v.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add(delayHeader, fmt.Sprintf("%d", 2*time.Second))
+
+		_, err = client.Do(req)
+
+		terr, ok := err.(*TimeoutError)
+		if !ok {
+			t.Errorf("%d: expected a TimeoutError, got %v", i, err)
+			continue
+		}
+		if terr.Phase != v.Phase {
+			t.Errorf("%d: expected phase %s, got %s", i, v.Phase, terr.Phase)
+		}
+		if terr.Elapsed < timeout {
+			t.Errorf("%d: expected at least %s elapsed, got %s", i, timeout, terr.Elapsed)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%d: expected errors.Is context.DeadlineExceeded", i)
+		}
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() {
+			t.Errorf("%d: expected a net.Error timeout", i)
+		}
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	go func() {
+		for {
+			conn, err := stalled.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	server := httptest.NewServer(&httpTestHandler{Mutex: new(sync.Mutex)})
+	defer server.CloseClientConnections()
+	defer server.Close()
+
+	limit := 100 * time.Millisecond
+
+	tests := []struct {
+		URL      string
+		Timeouts Timeouts
+		Phase    Phase
+	}{
+		// a DNS lookup that never answers
+		{"http://slow.invalid/", Timeouts{Dial: limit, Total: 5 * time.Second}, PhaseDial},
+		{"https://" + stalled.Addr().String() + "/", Timeouts{TLSHandshake: limit, Total: 5 * time.Second}, PhaseTLS},
+		{server.URL, Timeouts{ResponseHeader: limit, Total: 5 * time.Second}, PhaseHeaders},
+	}
+
+	for i, v := range tests {
+		client := NewClientTimeouts(v.Timeouts)
+		client.dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		}
+
+		req, err := http.NewRequest("GET", v.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add(delayHeader, fmt.Sprintf("%d", 2*time.Second))
+
+		start := time.Now()
+		_, err = client.Do(req)
+		elapsed := time.Since(start)
+
+		terr, ok := err.(*TimeoutError)
+		if !ok {
+			t.Errorf("%d: expected a TimeoutError, got %v", i, err)
+			continue
+		}
+		if terr.Phase != v.Phase {
+			t.Errorf("%d: expected phase %s, got %s", i, v.Phase, terr.Phase)
+		}
+		if elapsed > time.Second {
+			t.Errorf("%d: expected the %s timeout to expire first, took %s", i, v.Phase, elapsed)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package httpclient
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TokenSource supplies the bearer tokens used to answer RFC 6750
+// Bearer challenges.
+type TokenSource interface {
+	// Token returns a bearer token for the specified uri, realm
+	// and scope.  If refresh is true, the token last returned was
+	// rejected by the server and a new one should be obtained.  If
+	// no token could be found, NoCredentialsErr should be returned.
+	Token(uri *url.URL, realm string, scope []string, refresh bool) (token string, err error)
+}
+
+// ContextTokenSource is a TokenSource whose callers may give up
+// waiting on a token when ctx is done.  When a Session TokenSource
+// implements it, Bearer challenges are answered using the request
+// context.
+type ContextTokenSource interface {
+	TokenSource
+	TokenContext(ctx context.Context, uri *url.URL, realm string, scope []string, refresh bool) (token string, err error)
+}
+
+// ExpiringTokenSource is a TokenSource whose tokens may be cached by
+// the Session until they expire.  NewToken obtains a new token, and
+// the time after which it should be replaced; a zero expiry indicates
+// that the token does not expire.
+type ExpiringTokenSource interface {
+	TokenSource
+	NewToken(uri *url.URL, realm string, scope []string) (token string, expiry time.Time, err error)
+}
+
+// cachedToken is a token cached by a Session, and its expiry
+type cachedToken struct {
+	token  string
+	expiry time.Time
+}
+
+// tokenCall is a request for a new token in progress, shared by
+// concurrent callers asking for the same token.
+type tokenCall struct {
+	done  chan struct{}
+	token cachedToken
+	err   error
+}
+
+// tokenKey returns the key under which a token for uri, realm and
+// scope is cached.
+func tokenKey(uri *url.URL, realm string, scope []string) string {
+	var host string
+	if uri != nil {
+		host = uri.Host
+	}
+	return host + " " + realm + " " + strings.Join(scope, " ")
+}
+
+// tokenContext returns a token from tokens, via TokenContext if
+// tokens implements ContextTokenSource.
+func tokenContext(ctx context.Context, tokens TokenSource, uri *url.URL, realm string, scope []string, refresh bool) (token string, err error) {
+	if tokens, ok := tokens.(ContextTokenSource); ok {
+		return tokens.TokenContext(ctx, uri, realm, scope, refresh)
+	}
+	return tokens.Token(uri, realm, scope, refresh)
+}
+
+// StaticToken implements TokenSource, always returning the same
+// token.
+type StaticToken string
+
+func (t StaticToken) Token(uri *url.URL, realm string, scope []string, refresh bool) (token string, err error) {
+	if t == "" {
+		return "", NoCredentialsErr
+	}
+	return string(t), nil
+}
+
+// FileToken implements TokenSource, returning the contents of the
+// named file, less any surrounding whitespace.  The file is re-read
+// on each call, so that it may be rotated by another process.
+type FileToken string
+
+func (t FileToken) Token(uri *url.URL, realm string, scope []string, refresh bool) (token string, err error) {
+	b, err := ioutil.ReadFile(string(t))
+	if err != nil {
+		return
+	}
+	token = strings.TrimSpace(string(b))
+	if token == "" {
+		return "", NoCredentialsErr
+	}
+	return token, nil
+}
+
+// TokenFunc adapts an ordinary function to the TokenSource interface
+type TokenFunc func(uri *url.URL, realm string, scope []string, refresh bool) (token string, err error)
+
+func (f TokenFunc) Token(uri *url.URL, realm string, scope []string, refresh bool) (token string, err error) {
+	return f(uri, realm, scope, refresh)
+}
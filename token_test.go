@@ -0,0 +1,169 @@
+package httpclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bearerTestHandler accepts requests bearing token, rejecting any
+// other token as invalid_token.
+type bearerTestHandler struct {
+	sync.Mutex
+	token string
+	auth  []string
+}
+
+func (h *bearerTestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.Lock()
+	defer h.Unlock()
+
+	auth := req.Header.Get("Authorization")
+	h.auth = append(h.auth, auth)
+
+	switch auth {
+	case "Bearer " + h.token:
+		w.WriteHeader(http.StatusOK)
+	case "":
+		w.Header().Set("WWW-Authenticate", `Bearer realm="example", scope="read write"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	default:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="example", error="invalid_token", error_description="The access token expired"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+}
+
+func TestDoAuthBearer(t *testing.T) {
+	handler := &bearerTestHandler{token: "token-1"}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var refreshed int
+	tokens := TokenFunc(func(uri *url.URL, realm string, scope []string, refresh bool) (string, error) {
+		if refresh {
+			refreshed++
+		}
+		if realm != "example" {
+			t.Errorf("expected realm example, got %s", realm)
+		}
+		if refreshed > 0 {
+			return "token-2", nil
+		}
+		return "token-1", nil
+	})
+
+	session := NewTokenSession(nil, tokens, 1000, "", -1)
+	client := NewClient(time.Duration(1 * time.Second))
+
+	for i, token := range []string{"token-1", "token-2"} {
+		handler.Lock()
+		handler.token = token
+		handler.Unlock()
+
+		req, err := http.NewRequest("GET", server.URL+"/resource", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := client.DoAuth(req, session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusOK {
+			t.Errorf("%d: expected status %d, got %d", i, http.StatusOK, rsp.StatusCode)
+		}
+	}
+
+	if refreshed != 1 {
+		t.Errorf("expected 1 token refresh, got %d", refreshed)
+	}
+
+	expect := []string{"", "Bearer token-1", "Bearer token-1", "Bearer token-2"}
+	if len(handler.auth) != len(expect) {
+		t.Fatalf("expected %d requests, got %d", len(expect), len(handler.auth))
+	}
+	for i, v := range expect {
+		if handler.auth[i] != v {
+			t.Errorf("%d: expected Authorization %q, got %q", i, v, handler.auth[i])
+		}
+	}
+}
+
+// TestDoAuthBearerExpired checks a token rejected the first time it
+// is sent is refreshed.
+func TestDoAuthBearerExpired(t *testing.T) {
+	handler := &bearerTestHandler{token: "token-2"}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var refreshed int
+	tokens := TokenFunc(func(uri *url.URL, realm string, scope []string, refresh bool) (string, error) {
+		if refresh {
+			refreshed++
+			return "token-2", nil
+		}
+		return "token-1", nil
+	})
+
+	session := NewTokenSession(nil, tokens, 1000, "", -1)
+	client := NewClient(time.Duration(1 * time.Second))
+
+	req, err := http.NewRequest("GET", server.URL+"/resource", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := client.DoAuth(req, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rsp.StatusCode)
+	}
+	if refreshed != 1 {
+		t.Errorf("expected 1 token refresh, got %d", refreshed)
+	}
+
+	expect := []string{"", "Bearer token-1", "Bearer token-2"}
+	if strings.Join(handler.auth, "; ") != strings.Join(expect, "; ") {
+		t.Errorf("expected Authorization %q, got %q", expect, handler.auth)
+	}
+}
+
+func TestFileToken(t *testing.T) {
+	fh, err := ioutil.TempFile("", "FileToken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fh.Name())
+	fh.Close()
+
+	tokens := FileToken(fh.Name())
+
+	for _, v := range []string{"token-1", "token-2"} {
+		err = ioutil.WriteFile(fh.Name(), []byte(v+"\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := tokens.Token(nil, "", nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != v {
+			t.Errorf("expected %s, got %s", v, token)
+		}
+	}
+}
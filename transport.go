@@ -0,0 +1,315 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AuthTransport is an http.RoundTripper that answers the
+// WWW-Authenticate and Proxy-Authenticate challenges sent in response
+// to a request, using Session, in the same manner as Client.DoAuth.
+// Authorization cached in the Session is sent preemptively, and the
+// request body is duplicated via Session.Duplicate so that the
+// request may be resent.  It allows code that accepts only an
+// http.Client to use Basic, Digest and Bearer authentication.
+//
+// Base sends each request, and defaults to http.DefaultTransport.
+// Without a Session, requests are passed to Base unchanged.
+// If Base is an *http.Transport with a Proxy, its proxy challenges
+// to plain http requests are answered too.  Challenges to the CONNECT
+// request for an https tunnel are answered only if the Transport has
+// the hooks installed by SetProxyConnectHooks, as NewClient does.
+//
+// As an http.RoundTripper should, RoundTrip returns either a
+// response or an error: if a challenge cannot be answered, e.g., for
+// want of credentials, the challenge response is closed and the
+// error returned.
+type AuthTransport struct {
+	Base    http.RoundTripper
+	Session Session
+
+	// proxy, if set, selects the proxy for a request in place of
+	// the Base Transport Proxy.
+	proxy func(*http.Request) (*url.URL, error)
+}
+
+// RoundTrip sends req, resending it to answer challenges.
+func (t *AuthTransport) RoundTrip(req *http.Request) (rsp *http.Response, err error) {
+	session := t.Session
+	if session == nil {
+		return base(t.Base).RoundTrip(req)
+	}
+
+	// the caller gets either a response or an error, never both
+	defer func() {
+		if err != nil && rsp != nil {
+			rsp.Body.Close()
+			rsp = nil
+		}
+	}()
+
+	// the caller's body is closed by newReplay, or here if we
+	// return before it is reached
+	callerBody := req.Body
+	defer func() {
+		if callerBody != nil {
+			callerBody.Close()
+		}
+	}()
+
+	// the request headers and body are replaced as the
+	// challenges are answered
+	tunnel := &proxyTunnel{session: session}
+	req = req.Clone(context.WithValue(req.Context(), proxyTunnelKey{}, tunnel))
+	hooks := hooksFrom(req.Context())
+	telemetry := telemetryFrom(req.Context())
+
+	proxy, err := t.proxyURL(req)
+	if err != nil {
+		return
+	}
+
+	// a plain http request carries its own Proxy-Authorization,
+	// while the Transport sends it in the CONNECT request for an
+	// https tunnel.
+	if proxy != nil && req.URL.Scheme == "http" {
+		cached, auth := session.ProxyAuthorization(proxy)
+		auth, err = preemptive(session, req, cached, auth)
+		if err != nil {
+			return
+		}
+		if auth != "" {
+			req.Header.Set("Proxy-Authorization", auth)
+		}
+	}
+
+	cached, auth := session.Authorization(req.URL)
+	telemetry.cached(req, cached != nil || auth != "")
+	auth, err = preemptive(session, req, cached, auth)
+	if err != nil {
+		return
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	// copy the request body so that we may
+	// resubmit it if we need to re-authorize
+	if req.Body == callerBody {
+		callerBody = nil
+	}
+	body, err := newReplay(session, req)
+	if err != nil {
+		return
+	}
+	defer body.Close()
+
+	rsp, err = base(t.Base).RoundTrip(req)
+
+	// retry the request if the proxy challenged the
+	// CONNECT request for an https tunnel
+	if err != nil {
+		if challenges := tunnel.challenged(); challenges != nil {
+			hooks.challenge(req, http.StatusProxyAuthRequired, challenges)
+			telemetry.challenge(req, http.StatusProxyAuthRequired, challenges)
+			rsp, err = t.tunnel(req, body, tunnel, challenges)
+		}
+	}
+
+	// retry the request w/ Proxy-Authorization if challenged
+	if err == nil && rsp.StatusCode == http.StatusProxyAuthRequired && proxy != nil {
+		var challenges Challenges
+		challenges, err = ProxyAuthentication(rsp, proxy)
+		if err != nil {
+			err = fmt.Errorf("unable to parse %s Proxy-Authenticate: %v", proxy.String(), err)
+			return
+		}
+
+		hooks.challenge(req, rsp.StatusCode, challenges)
+		telemetry.challenge(req, rsp.StatusCode, challenges)
+
+		if len(challenges) == 0 {
+			err = fmt.Errorf("unable to parse %s Proxy-Authenticate header: %s",
+				proxy.String(), rsp.Header.Get("Proxy-Authenticate"))
+			return
+		}
+
+		var answered *Challenge
+		var proxyAuth string
+		rsp, answered, proxyAuth, err = t.answer(req, session, body, rsp, challenges.Preferred(),
+			"Proxy-Authorization", http.StatusProxyAuthRequired)
+		if answered != nil {
+			session.SetProxyAuthorization(proxy, answered.Answered(), proxyAuth)
+		}
+	}
+
+	if err == nil && rsp.StatusCode != http.StatusUnauthorized && cached != nil && cached.Scheme == "Digest" {
+		cached, err = digestInfo(session, req, rsp, cached)
+		if err != nil {
+			rsp.Body.Close()
+			return nil, err
+		}
+		session.SetAuthorization(req.URL, cached.Domain, cached, auth)
+		return
+	}
+
+	// retry the request w/ Authorization if challenged
+	if err == nil && rsp.StatusCode == http.StatusUnauthorized {
+		var challenges Challenges
+		challenges, err = Authentication(rsp)
+		if err != nil {
+			err = fmt.Errorf("unable to parse %s WWW-Authenticate: %v", req.URL.String(), err)
+			return
+		}
+
+		hooks.challenge(req, rsp.StatusCode, challenges)
+		telemetry.challenge(req, rsp.StatusCode, challenges)
+
+		challenges = challenges.Preferred()
+
+		// a stale nonce only requires that we recompute the
+		// response, so retry once with just that challenge.
+		for _, challenge := range challenges {
+			if challenge.Scheme == "Digest" && challenge.Stale {
+				challenges = Challenges{challenge}
+				break
+			}
+		}
+
+		if len(challenges) == 0 {
+			err = fmt.Errorf("unable to parse %s WWW-Authenticate header: %s",
+				req.URL.String(), rsp.Header.Get("Www-Authenticate"))
+			return
+		}
+
+		var answered *Challenge
+		rsp, answered, auth, err = t.answer(req, session, body, rsp, challenges,
+			"Authorization", http.StatusUnauthorized)
+
+		// a token rejected as invalid_token, e.g., one that had
+		// expired before it was first sent, is refreshed and the
+		// request resent once.
+		if err == nil && answered == nil && rsp.StatusCode == http.StatusUnauthorized &&
+			strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") && invalidToken(challenges) == nil {
+			challenges, _ = Authentication(rsp)
+			if challenge := invalidToken(challenges); challenge != nil {
+				hooks.challenge(req, rsp.StatusCode, challenges)
+				telemetry.challenge(req, rsp.StatusCode, challenges)
+				rsp, answered, auth, err = t.answer(req, session, body, rsp, Challenges{challenge},
+					"Authorization", http.StatusUnauthorized)
+			}
+		}
+
+		if answered != nil {
+			next := answered.Answered()
+			if answered.Scheme == "Digest" {
+				next, err = digestInfo(session, req, rsp, answered)
+				if err != nil {
+					rsp.Body.Close()
+					return nil, err
+				}
+			}
+			session.SetAuthorization(req.URL, answered.Domain, next, auth)
+		}
+	}
+
+	return
+}
+
+// invalidToken returns the Bearer challenge among challenges that
+// rejected the token sent as invalid_token, or nil.
+func invalidToken(challenges Challenges) *Challenge {
+	for _, challenge := range challenges {
+		if challenge.Scheme == "Bearer" && challenge.Error == "invalid_token" {
+			return challenge
+		}
+	}
+	return nil
+}
+
+// proxyURL returns the proxy that will be used for req, or nil if
+// req will be sent directly.
+func (t *AuthTransport) proxyURL(req *http.Request) (*url.URL, error) {
+	if t.proxy != nil {
+		return t.proxy(req)
+	}
+	if transport, ok := t.Base.(*http.Transport); ok && transport.Proxy != nil {
+		return transport.Proxy(req)
+	}
+	return nil, nil
+}
+
+// answer tries each of the challenges in turn, resending req with
+// the answer set in header, until the response status is something
+// other than status.  The challenge answered successfully and the
+// header value sent are returned, so that the caller may cache them.
+// The body of the challenge response rsp is closed before req is
+// resent.
+func (t *AuthTransport) answer(req *http.Request, session Session, body *replay, rsp *http.Response, challenges Challenges, header string, status int) (last *http.Response, answered *Challenge, auth string, err error) {
+	last = rsp
+
+	n := len(challenges)
+	for i, challenge := range challenges {
+		lastTry := i+1 == n
+
+		// the request body must be restored before answering,
+		// as an auth-int Digest challenge consumes it.
+		err = body.rewind(req)
+		if err != nil {
+			return
+		}
+
+		auth, err = challenge.Authorization(session, req)
+		if err != nil {
+			if err == NoCredentialsErr && !lastTry {
+				continue
+			}
+			return
+		}
+
+		if auth != "" {
+			req.Header.Set(header, auth)
+
+			if last != nil {
+				last.Body.Close()
+			}
+
+			sent, end := telemetryFrom(req.Context()).answer(req, challenge)
+			last, err = base(t.Base).RoundTrip(sent)
+			end(last, err)
+			if err == nil && last.StatusCode != status {
+				return last, challenge, auth, nil
+			}
+		}
+	}
+
+	return
+}
+
+// tunnel resends req, answering each of the challenges the proxy
+// sent in response to the CONNECT request, until the tunnel is
+// established or we run out of challenges to answer.
+func (t *AuthTransport) tunnel(req *http.Request, body *replay, tunnel *proxyTunnel, challenges Challenges) (rsp *http.Response, err error) {
+	defer tunnel.answer(nil)
+
+	for _, challenge := range challenges {
+		tunnel.answer(challenge)
+
+		err = body.rewind(req)
+		if err != nil {
+			return
+		}
+
+		sent, end := telemetryFrom(req.Context()).answer(req, challenge)
+		rsp, err = base(t.Base).RoundTrip(sent)
+		end(rsp, err)
+		if err == nil || tunnel.challenged() == nil {
+			return
+		}
+	}
+
+	return
+}
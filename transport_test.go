@@ -0,0 +1,187 @@
+package httpclient
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestAuthTransport answers Digest challenges with a plain
+// http.Client, as a third party API client would use it.
+func TestAuthTransport(t *testing.T) {
+	handler := newDigestTestHandler("nonce-1")
+
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		mu.Unlock()
+		handler.ServeHTTP(w, req)
+	}))
+	defer server.CloseClientConnections()
+	defer server.Close()
+
+	credentials := &OrderedCredentials{[]Credential{NewCredential("", "/", handler.username, handler.password)}}
+
+	// a limit of 8 bytes spills the body to a temporary file
+	session := NewSession(credentials, 1000, "", 8)
+
+	client := &http.Client{
+		Transport: &AuthTransport{Session: session},
+	}
+
+	for i := 0; i < 3; i++ {
+		body := fmt.Sprintf("request body %d", i)
+
+		req, err := http.NewRequest("POST", server.URL+"/api", ioutil.NopCloser(strings.NewReader(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusOK {
+			t.Errorf("%d: expected status %d, got %d", i, http.StatusOK, rsp.StatusCode)
+		}
+		if req.Header.Get("Authorization") != "" {
+			t.Errorf("%d: expected the caller's request to be left unmodified", i)
+		}
+	}
+
+	// the first request is challenged and resent with its body,
+	// the rest are authorized preemptively from the cache
+	expected := []string{"request body 0", "request body 0", "request body 1", "request body 2"}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != len(expected) {
+		t.Fatalf("expected %d requests, got %d", len(expected), len(bodies))
+	}
+	for i := range expected {
+		if bodies[i] != expected[i] {
+			t.Errorf("%d: expected body %q, got %q", i, expected[i], bodies[i])
+		}
+	}
+}
+
+func TestAuthTransportNoSession(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &AuthTransport{}}
+
+	rsp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rsp.StatusCode)
+	}
+}
+
+type closeTracker struct {
+	io.ReadCloser
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return c.ReadCloser.Close()
+}
+
+// TestAuthTransportNoCredentials checks a challenge that cannot be
+// answered yields an error alone, with the challenge response closed.
+func TestAuthTransportNoCredentials(t *testing.T) {
+	handler := newDigestTestHandler("nonce-1")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var bodies []*closeTracker
+	base := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		rsp, err := http.DefaultTransport.RoundTrip(req)
+		if err == nil {
+			body := &closeTracker{ReadCloser: rsp.Body}
+			bodies = append(bodies, body)
+			rsp.Body = body
+		}
+		return rsp, err
+	})
+
+	session := NewSession(&OrderedCredentials{}, 1000, "", -1)
+	client := &http.Client{Transport: &AuthTransport{Base: base, Session: session}}
+
+	rsp, err := client.Get(server.URL + "/api")
+	if rsp != nil {
+		rsp.Body.Close()
+		t.Errorf("expected no response, got status %d", rsp.StatusCode)
+	}
+	if uerr, ok := err.(*url.Error); !ok || uerr.Err != NoCredentialsErr {
+		t.Errorf("expected NoCredentialsErr, got %v", err)
+	}
+
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(bodies))
+	}
+	if !bodies[0].closed {
+		t.Error("expected the challenge response body to be closed")
+	}
+}
+
+// TestAuthTransportCloseBody checks the request body is closed when
+// RoundTrip fails before sending the request.
+func TestAuthTransportCloseBody(t *testing.T) {
+	tokens := TokenFunc(func(uri *url.URL, realm string, scope []string, refresh bool) (string, error) {
+		return "", fmt.Errorf("no token")
+	})
+
+	tests := []struct {
+		Transport *AuthTransport
+	}{
+		// the proxy cannot be selected
+		{&AuthTransport{
+			Session: NewSession(&OrderedCredentials{}, 1000, "", -1),
+			proxy: func(*http.Request) (*url.URL, error) {
+				return nil, fmt.Errorf("no proxy")
+			},
+		}},
+		// the preemptive authorization fails
+		{&AuthTransport{
+			Session: NewTokenSession(nil, tokens, 1000, "", -1),
+		}},
+	}
+
+	uri, _ := url.Parse("http://example.org/")
+	tests[1].Transport.Session.SetAuthorization(uri, nil, &Challenge{Scheme: "Bearer"}, "Bearer expired")
+
+	for i, v := range tests {
+		body := &closeTracker{ReadCloser: ioutil.NopCloser(strings.NewReader("request body"))}
+		req, err := http.NewRequest("POST", uri.String(), body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := v.Transport.RoundTrip(req)
+		if err == nil {
+			rsp.Body.Close()
+			t.Errorf("%d: expected an error", i)
+		}
+		if !body.closed {
+			t.Errorf("%d: expected the request body to be closed", i)
+		}
+	}
+}